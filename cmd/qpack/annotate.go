@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/quic-go/qpack/qif"
+	"github.com/quic-go/qpack/wire"
+)
+
+func runAnnotate(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("annotate", flag.ContinueOnError)
+	f := formatQIF
+	fs.Var(&f, "format", "input format: qif, hex or base64")
+	stream := fs.String("stream", "auto", "the kind of data: auto (encoder stream on stream 0, field sections on all other streams),\n"+
+		"field (field sections), encoder (encoder stream) or decoder (decoder stream)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch *stream {
+	case "auto", "field", "encoder", "decoder":
+	default:
+		return fmt.Errorf("invalid stream kind %q", *stream)
+	}
+
+	return openInputs(fs.Args(), stdin, func(name string, r io.Reader) error {
+		records, err := readRecords(r, f)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			fmt.Fprintf(stdout, "# %s\n", name)
+		}
+		for _, rec := range records {
+			kind := *stream
+			if kind == "auto" {
+				kind = "field"
+				if rec.StreamID == qif.EncoderStreamID {
+					kind = "encoder"
+				}
+			}
+			var t wire.DataType
+			switch kind {
+			case "field":
+				t = wire.FieldSection
+			case "encoder":
+				t = wire.EncoderStream
+			case "decoder":
+				t = wire.DecoderStream
+			}
+			fmt.Fprintf(stdout, "# stream %d: %s (%d bytes)\n", rec.StreamID, t, len(rec.Data))
+			// Problems are printed next to the affected instruction.
+			instructions, _ := wire.Explain(t, rec.Data)
+			for _, in := range instructions {
+				fmt.Fprintln(stdout, in)
+			}
+			fmt.Fprintln(stdout)
+		}
+		return nil
+	})
+}
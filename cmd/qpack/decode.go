@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/quic-go/qpack"
+	"github.com/quic-go/qpack/qif"
+)
+
+func runDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	f := formatQIF
+	fs.Var(&f, "format", "input format: qif, hex or base64")
+	tableSize := fs.Uint64("table-size", 0, "maximum dynamic table capacity (default: taken from the name of encoded files, or 0)")
+	maxBlocked := fs.Uint64("max-blocked", 0, "maximum number of blocked streams (default: taken from the name of encoded files, or 0)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flagSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+
+	return openInputs(fs.Args(), stdin, func(name string, r io.Reader) error {
+		records, err := readRecords(r, f)
+		if err != nil {
+			return err
+		}
+		d := sectionDecoder{w: stdout, tableSize: *tableSize, maxBlocked: *maxBlocked}
+		// Encoded files produced for the interop tests contain the decoder settings in their name.
+		if params, err := qif.ParseEncodedFileName(filepath.Base(name)); err == nil {
+			if !flagSet["table-size"] {
+				d.tableSize = params.TableSize
+			}
+			if !flagSet["max-blocked"] {
+				d.maxBlocked = params.MaxBlockedStreams
+			}
+		}
+		if name != "" {
+			fmt.Fprintf(stdout, "# %s\n", name)
+		}
+		return d.decode(records)
+	})
+}
+
+// A sectionDecoder decodes the records of an encoded file.
+// Field sections that are blocked on the encoder stream are decoded
+// once the encoder stream data they depend on has been received.
+type sectionDecoder struct {
+	w                     io.Writer
+	tableSize, maxBlocked uint64
+	blocked               []pendingSection
+}
+
+type pendingSection struct {
+	streamID uint64
+	decode   qpack.DecodeFunc
+}
+
+func (d *sectionDecoder) decode(records []qif.Record) error {
+	dec := qpack.NewDecoder(qpack.WithMaxTableCapacity(d.tableSize), qpack.WithMaxBlockedStreams(d.maxBlocked))
+	for _, rec := range records {
+		if rec.StreamID == qif.EncoderStreamID {
+			if err := dec.HandleEncoderStream(rec.Data); err != nil {
+				return fmt.Errorf("encoder stream: %w", err)
+			}
+			blocked := d.blocked
+			d.blocked = nil
+			for _, s := range blocked {
+				if err := d.decodeSection(s); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := d.decodeSection(pendingSection{streamID: rec.StreamID, decode: dec.DecodeStream(rec.StreamID, rec.Data)}); err != nil {
+			return err
+		}
+	}
+	for _, s := range d.blocked {
+		fmt.Fprintf(d.w, "# stream %d: blocked on the encoder stream\n\n", s.streamID)
+	}
+	if len(d.blocked) > 0 {
+		return fmt.Errorf("%d field sections blocked at the end of the input", len(d.blocked))
+	}
+	return nil
+}
+
+// decodeSection decodes and prints a field section, unless it is blocked.
+func (d *sectionDecoder) decodeSection(s pendingSection) error {
+	var fields []qpack.HeaderField
+	for {
+		hf, err := s.decode()
+		if err == io.EOF {
+			break
+		}
+		if err == qpack.ErrBlocked {
+			d.blocked = append(d.blocked, s)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stream %d: %w", s.streamID, err)
+		}
+		fields = append(fields, hf)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# stream %d\n", s.streamID)
+	if err := qif.Write(&buf, [][]qpack.HeaderField{fields}); err != nil {
+		return fmt.Errorf("stream %d: %w", s.streamID, err)
+	}
+	buf.WriteByte('\n')
+	_, err := d.w.Write(buf.Bytes())
+	return err
+}
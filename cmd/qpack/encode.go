@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/quic-go/qpack"
+	"github.com/quic-go/qpack/qif"
+)
+
+func runEncode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("encode", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "usage: qpack encode [flags] [file ...]\n\n"+
+			"Blocked streams are not supported: the encoder only references dynamic table entries\n"+
+			"once they have been acknowledged, so field sections are never blocked on the encoder stream.\n\n"+
+			"Flags:\n")
+		fs.PrintDefaults()
+	}
+	f := formatQIF
+	fs.Var(&f, "format", "output format: qif, hex or base64")
+	tableSize := fs.Uint64("table-size", 0, "the decoder's maximum dynamic table capacity")
+	immediateAck := fs.Bool("immediate-ack", true, "assume that every field section is acknowledged immediately.\n"+
+		"The encoder only references dynamic table entries that were acknowledged.")
+	huffman := fs.String("huffman", "if-shorter", "Huffman encoding: if-shorter, always or never")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var policy qpack.HuffmanPolicy
+	switch *huffman {
+	case "if-shorter":
+		policy = qpack.HuffmanIfShorter
+	case "always":
+		policy = qpack.HuffmanAlways
+	case "never":
+		policy = qpack.HuffmanNever
+	default:
+		return fmt.Errorf("invalid Huffman policy %q", *huffman)
+	}
+
+	var blocks [][]qpack.HeaderField
+	if err := openInputs(fs.Args(), stdin, func(_ string, r io.Reader) error {
+		b, err := qif.Read(r)
+		blocks = append(blocks, b...)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var encoderStream, decoderStream bytes.Buffer
+	enc := qpack.NewEncoder(nil, qpack.WithEncoderStream(&encoderStream, *tableSize), qpack.WithHuffmanPolicy(policy))
+	// The decoder is only used to generate acknowledgments.
+	dec := qpack.NewDecoder(
+		qpack.WithMaxTableCapacity(*tableSize),
+		qpack.WithDecoderStream(&decoderStream),
+	)
+	for i, fields := range blocks {
+		streamID := uint64(i + 1)
+		enc.SetStreamID(streamID)
+		data, err := enc.AppendFieldSection(nil, fields)
+		if err != nil {
+			return fmt.Errorf("header block %d: %w", i+1, err)
+		}
+		if encoderStream.Len() > 0 {
+			if err := writeRecord(stdout, qif.Record{StreamID: qif.EncoderStreamID, Data: encoderStream.Bytes()}, f); err != nil {
+				return err
+			}
+			if err := dec.HandleEncoderStream(encoderStream.Bytes()); err != nil {
+				return err
+			}
+			encoderStream.Reset()
+		}
+		if err := writeRecord(stdout, qif.Record{StreamID: streamID, Data: data}, f); err != nil {
+			return err
+		}
+		if !*immediateAck {
+			continue
+		}
+		for _, err := range dec.DecodeStream(streamID, data).All() {
+			if err != nil {
+				return fmt.Errorf("header block %d: %w", i+1, err)
+			}
+		}
+		if err := enc.HandleDecoderStream(decoderStream.Bytes()); err != nil {
+			return err
+		}
+		decoderStream.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/qpack/qif"
+)
+
+// A format is the format of encoded input and output.
+type format string
+
+const (
+	formatQIF    format = "qif"
+	formatHex    format = "hex"
+	formatBase64 format = "base64"
+)
+
+func (f *format) String() string { return string(*f) }
+
+func (f *format) Set(s string) error {
+	switch format(s) {
+	case formatQIF, formatHex, formatBase64:
+		*f = format(s)
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", s)
+	}
+}
+
+// readRecords reads all records from r.
+func readRecords(r io.Reader, f format) ([]qif.Record, error) {
+	if f == formatQIF {
+		var records []qif.Record
+		for {
+			rec, err := qif.ReadRecord(r)
+			if err == io.EOF {
+				return records, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+	}
+
+	var records []qif.Record
+	var nextStreamID uint64 = 1
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rec, err := parseLine(line, f, nextStreamID)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if rec.StreamID != qif.EncoderStreamID {
+			nextStreamID = rec.StreamID + 1
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
+
+func parseLine(line string, f format, streamID uint64) (qif.Record, error) {
+	// A stream ID is a decimal number followed by a colon and a space.
+	// Base64 doesn't use colons, and colons in hex data must not be followed by a space.
+	// This rules out hex dumps with an offset column, like the output of xxd:
+	// The offset would be taken as the stream ID.
+	if prefix, rest, ok := strings.Cut(line, ": "); ok {
+		id, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return qif.Record{}, fmt.Errorf("invalid stream ID %q", prefix)
+		}
+		streamID = id
+		line = rest
+	}
+	var data []byte
+	var err error
+	switch f {
+	case formatHex:
+		data, err = hex.DecodeString(strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '\t', ':':
+				return -1
+			}
+			return r
+		}, line))
+	case formatBase64:
+		data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	case formatQIF:
+		return qif.Record{}, errors.New("QIF data is not line-based")
+	}
+	if err != nil {
+		return qif.Record{}, err
+	}
+	return qif.Record{StreamID: streamID, Data: data}, nil
+}
+
+// writeRecord writes a record to w.
+func writeRecord(w io.Writer, rec qif.Record, f format) error {
+	var err error
+	switch f {
+	case formatQIF:
+		err = qif.WriteRecord(w, rec)
+	case formatHex:
+		_, err = fmt.Fprintf(w, "%d: %x\n", rec.StreamID, rec.Data)
+	case formatBase64:
+		_, err = fmt.Fprintf(w, "%d: %s\n", rec.StreamID, base64.StdEncoding.EncodeToString(rec.Data))
+	}
+	return err
+}
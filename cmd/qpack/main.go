@@ -0,0 +1,88 @@
+// Command qpack decodes, encodes and annotates QPACK field sections and encoder stream data.
+//
+// Usage:
+//
+//	qpack decode [flags] [file ...]
+//	qpack encode [flags] [file ...]
+//	qpack annotate [flags] [file ...]
+//
+// If no file is given, the input is read from stdin.
+//
+// Encoded input and output use one of the following formats, selected using the -format flag:
+//
+//   - qif: the encoded file format used by the QPACK interop tests, see package qif.
+//   - hex, base64: one block per line. A line can be prefixed with a stream ID followed by a colon
+//     and a space, e.g. "0: 3fe11f", with stream 0 being the encoder stream.
+//     Lines without a stream ID contain a field section, and are numbered consecutively.
+//     Empty lines and lines starting with # are ignored. Whitespace and colons are ignored in hex data,
+//     e.g. "00:00:51:0b". Hex dumps with offset or ASCII columns, like the default output of xxd,
+//     are not supported.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: qpack <command> [flags] [file ...]
+
+Commands:
+  decode    decode field sections, and print the header fields in the QIF format
+  encode    encode header blocks read from QIF files (blocked streams are not supported)
+  annotate  print a disassembly of field sections and encoder stream instructions
+
+Run qpack <command> -h for the flags of a command.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "qpack:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+	var cmd func([]string, io.Reader, io.Writer) error
+	switch args[0] {
+	case "decode":
+		cmd = runDecode
+	case "encode":
+		cmd = runEncode
+	case "annotate":
+		cmd = runAnnotate
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd(args[1:], stdin, stdout)
+}
+
+// openInputs calls fn for every file, or for stdin if no files are given.
+func openInputs(files []string, stdin io.Reader, fn func(name string, r io.Reader) error) error {
+	if len(files) == 0 {
+		return fn("", stdin)
+	}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = fn(name, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/qpack/qif"
+	"github.com/stretchr/testify/require"
+)
+
+const testQIF = `:method	GET
+:scheme	https
+:authority	quic-go.net
+:path	/
+user-agent	qpack
+
+:method	GET
+:scheme	https
+:authority	quic-go.net
+:path	/index.html
+user-agent	qpack
+`
+
+func runCommand(t *testing.T, stdin []byte, args ...string) []byte {
+	t.Helper()
+	var stdout bytes.Buffer
+	require.NoError(t, run(args, bytes.NewReader(stdin), &stdout))
+	return stdout.Bytes()
+}
+
+func TestEncodeDecode(t *testing.T) {
+	expected, err := qif.Read(strings.NewReader(testQIF))
+	require.NoError(t, err)
+
+	for _, f := range []format{formatQIF, formatHex, formatBase64} {
+		for _, tableSize := range []string{"0", "4096"} {
+			t.Run(fmt.Sprintf("%s, table size %s", f, tableSize), func(t *testing.T) {
+				encoded := runCommand(t, []byte(testQIF), "encode", "-format", string(f), "-table-size", tableSize)
+				decoded := runCommand(t, encoded, "decode", "-format", string(f), "-table-size", tableSize)
+				blocks, err := qif.Read(bytes.NewReader(decoded))
+				require.NoError(t, err)
+				require.Equal(t, expected, blocks)
+				require.Contains(t, string(decoded), "# stream 2\n")
+			})
+		}
+	}
+}
+
+func TestEncodeUsesDynamicTable(t *testing.T) {
+	encode := func(args ...string) []qif.Record {
+		records, err := readRecords(bytes.NewReader(runCommand(t, []byte(testQIF), append([]string{"encode"}, args...)...)), formatQIF)
+		require.NoError(t, err)
+		return records
+	}
+
+	records := encode("-table-size", "4096")
+	require.Len(t, records, 4) // encoder stream, field section, encoder stream, field section
+	require.Equal(t, uint64(qif.EncoderStreamID), records[0].StreamID)
+	require.Equal(t, uint64(1), records[1].StreamID)
+	require.Equal(t, uint64(2), records[3].StreamID)
+	// Without acknowledgments, the encoder doesn't reference the dynamic table.
+	unacked := encode("-table-size", "4096", "-immediate-ack=false")
+	require.Less(t, len(records[3].Data), len(unacked[len(unacked)-1].Data))
+}
+
+func TestDecodeFileName(t *testing.T) {
+	// the table size is taken from the file name
+	encoded := runCommand(t, []byte(testQIF), "encode", "-table-size", "4096")
+	path := filepath.Join(t.TempDir(), "test.out.4096.0.1")
+	require.NoError(t, os.WriteFile(path, encoded, 0o644))
+	decoded := runCommand(t, nil, "decode", path)
+	require.Contains(t, string(decoded), "# "+path+"\n")
+	blocks, err := qif.Read(bytes.NewReader(decoded))
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+
+	require.Error(t, run([]string{"decode", "-table-size", "0", path}, nil, &bytes.Buffer{}))
+}
+
+func TestDecodeHex(t *testing.T) {
+	// Appendix B.1 of RFC 9204, and a copy of it using a stream ID prefix
+	const input = "# a comment\n0000 510b 2f69 6e64 6578 2e68 746d 6c\n\n8: 00:00:51:0b:2f:69:6e:64:65:78:2e:68:74:6d:6c\n"
+	require.Equal(t,
+		"# stream 1\n:path\t/index.html\n\n# stream 8\n:path\t/index.html\n\n",
+		string(runCommand(t, []byte(input), "decode", "-format", "hex")),
+	)
+}
+
+func TestDecodeBlocked(t *testing.T) {
+	// The field section references a dynamic table entry that's inserted afterwards.
+	const input = "4: 0200 80\n0: 3fe11f 43666f6f03626172\n"
+	out := runCommand(t, []byte(input), "decode", "-format", "hex", "-table-size", "4096", "-max-blocked", "1")
+	require.Equal(t, "# stream 4\nfoo\tbar\n\n", string(out))
+
+	var stdout bytes.Buffer
+	err := run([]string{"decode", "-format", "hex", "-table-size", "4096", "-max-blocked", "1"}, strings.NewReader("4: 0200 80\n"), &stdout)
+	require.EqualError(t, err, "1 field sections blocked at the end of the input")
+	require.Equal(t, "# stream 4: blocked on the encoder stream\n\n", stdout.String())
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input, err string
+	}{
+		{input: "0000 zz", err: "line 1: encoding/hex: invalid byte: U+007A 'z'"},
+		{input: "foo: 0000", err: `line 1: invalid stream ID "foo"`},
+		{input: "0000 ff", err: "stream 1: QPACK_DECOMPRESSION_FAILED"},
+	} {
+		err := run([]string{"decode", "-format", "hex"}, strings.NewReader(tc.input), &bytes.Buffer{})
+		require.Error(t, err)
+		require.True(t, strings.HasPrefix(err.Error(), tc.err), err.Error())
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	// Appendix B.2 of RFC 9204
+	const input = "0: 3fbd01c00f7777772e6578616d706c652e636f6dc10c2f73616d706c652f70617468\n4: 03811011\n"
+	expected := `# stream 0: encoder stream (34 bytes)
+0000  3f bd 01                        Set Dynamic Table Capacity: capacity=220
+0003  c0 0f 77 77 77 2e 65 78 (+9)    Insert with Name Reference: T=1 index=0 value="www.example.com" (H=0)
+0014  c1 0c 2f 73 61 6d 70 6c (+6)    Insert with Name Reference: T=1 index=1 value="/sample/path" (H=0)
+
+# stream 4: field section (4 bytes)
+0000  03 81                           Encoded Field Section Prefix: Encoded Insert Count=3 S=1 Delta Base=1
+0002  10                              Indexed Field Line with Post-Base Index: index=0
+0003  11                              Indexed Field Line with Post-Base Index: index=1
+
+`
+	require.Equal(t, expected, string(runCommand(t, []byte(input), "annotate", "-format", "hex")))
+}
+
+func TestAnnotateDecoderStream(t *testing.T) {
+	out := runCommand(t, []byte("hAFI\n"), "annotate", "-format", "base64", "-stream", "decoder")
+	require.Equal(t, `# stream 1: decoder stream (3 bytes)
+0000  84                              Section Acknowledgment: stream=4
+0001  01                              Insert Count Increment: increment=1
+0002  48                              Stream Cancellation: stream=8
+
+`, string(out))
+}
+
+func TestAnnotateInvalid(t *testing.T) {
+	out := runCommand(t, []byte("0000 51ff\n"), "annotate", "-format", "hex")
+	require.Contains(t, string(out), "0002  51 ff                           Literal Field Line with Name Reference (error: unexpected EOF)\n")
+}
+
+func TestUnknownCommand(t *testing.T) {
+	require.EqualError(t, run([]string{"foo"}, nil, &bytes.Buffer{}), `unknown command "foo"`)
+}
@@ -1,10 +1,15 @@
 package qpack
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"math"
+	"sync"
 
+	"github.com/quic-go/qpack/wire"
 	"golang.org/x/net/http2/hpack"
 )
 
@@ -16,168 +21,1196 @@ func (e invalidIndexError) Error() string {
 	return fmt.Sprintf("invalid indexed representation index %d", int(e))
 }
 
-var errNoDynamicTable = errors.New("no dynamic table")
+var (
+	errInvalidRequiredInsertCount = errors.New("invalid Required Insert Count")
+	errInvalidBase                = errors.New("invalid Base")
+	errInvalidDynamicIndex        = errors.New("invalid dynamic table index")
+	errTooManyBlockedStreams      = errors.New("too many blocked streams")
+	errCapacityExceeded           = errors.New("dynamic table capacity exceeds the maximum table capacity")
+	errInstructionTooLarge        = errors.New("encoder stream instruction too large")
+)
+
+// ErrFieldSectionTooLarge is returned by a DecodeFunc when the size of the field section
+// exceeds the limit configured using WithMaxFieldSectionSize.
+// This is not a QPACK error: the field section is valid, but the HTTP message must be rejected,
+// see Section 4.2.2 of RFC 9114.
+// If the dynamic table is used, the caller should call CancelStream when abandoning the field section.
+var ErrFieldSectionTooLarge = errors.New("field section too large")
+
+// ErrStreamCanceled is returned by a DecodeFunc returned by DecodeContext
+// when CancelStream is called for the stream while waiting for the encoder stream.
+var ErrStreamCanceled = errors.New("stream canceled while blocked on the encoder stream")
+
+// ErrStreamRequired is returned by Decode, Fields, DecodeAll, DecodeInto, DecodeBytes and DecodeWithInfo
+// when the header block references the dynamic table.
+// Such header blocks must be acknowledged on the decoder stream, and they can be blocked on the encoder stream,
+// which is only possible if the stream ID is known. DecodeStream should be used instead.
+// This is not a QPACK error: the header block might be valid.
+var ErrStreamRequired = errors.New("header block references the dynamic table, and must be decoded with a stream ID")
+
+// ErrBlocked is returned by a DecodeFunc when the header block references dynamic table entries
+// that haven't been received on the encoder stream yet.
+// Decoding can be resumed by calling the DecodeFunc again once the channel returned by
+// Decoder.Unblocked is closed.
+var ErrBlocked = errors.New("header block is blocked on the encoder stream")
+
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// A blockedStream is a stream with a header block that can't be decoded yet.
+type blockedStream struct {
+	requiredInsertCount uint64
+	unblocked           chan struct{} // closed once the stream is unblocked, or when it is canceled
+	canceled            bool          // set by CancelStream before closing unblocked
+}
 
 // A Decoder decodes QPACK header blocks.
 // A Decoder can be reused to decode multiple header blocks on different streams
 // on the same connection (e.g., headers then trailers).
-// This will be useful when dynamic table support is added.
-type Decoder struct{}
+//
+// If the Decoder is configured with a non-zero maximum table capacity,
+// the peer's encoder stream needs to be passed to HandleEncoderStream,
+// and decoder stream instructions are written to the writer configured using WithDecoderStream.
+// It is safe to call HandleEncoderStream concurrently with decoding header blocks.
+type Decoder struct {
+	mutex sync.Mutex
+
+	maxTableCapacity  uint64
+	maxBlockedStreams uint64
+	// the maximum size of a field section, or noLimit
+	maxFieldSectionSize uint64
+	table               dynamicTable
+	blockedStreams      map[uint64]*blockedStream
+
+	// data received on the encoder stream that doesn't form a complete instruction yet
+	encoderStreamBuf []byte
+
+	decoderStream io.Writer
+	// the Insert Count that the encoder knows the decoder has received,
+	// either via Section Acknowledgments or via Insert Count Increments
+	knownReceivedCount uint64
+
+	// writeMutex serializes writes to the decoder stream, and protects instructionBuf.
+	// It is acquired before the mutex is released, so that instructions are written in order.
+	writeMutex     sync.Mutex
+	instructionBuf []byte
+}
+
+// A DecoderOption configures a Decoder.
+type DecoderOption func(*Decoder)
+
+// WithMaxTableCapacity sets the maximum capacity of the dynamic table.
+// This is the value sent to the peer in the SETTINGS_QPACK_MAX_TABLE_CAPACITY setting.
+// By default, the maximum capacity is 0, which means that the dynamic table is not used.
+func WithMaxTableCapacity(capacity uint64) DecoderOption {
+	return func(d *Decoder) { d.maxTableCapacity = capacity }
+}
+
+// WithMaxBlockedStreams sets the maximum number of streams that can be blocked
+// on the encoder stream at the same time.
+// This is the value sent to the peer in the SETTINGS_QPACK_BLOCKED_STREAMS setting.
+// By default, no streams can be blocked.
+func WithMaxBlockedStreams(n uint64) DecoderOption {
+	return func(d *Decoder) { d.maxBlockedStreams = n }
+}
+
+// WithMaxFieldSectionSize sets the maximum size of a field section.
+// This is the value sent to the peer in the SETTINGS_MAX_FIELD_SECTION_SIZE setting.
+// The size is calculated as described in Section 4.2.2 of RFC 9114:
+// the sum of the length of the name and value of every field, plus 32 bytes per field.
+// If a field section exceeds this size, decoding is aborted with ErrFieldSectionTooLarge,
+// before the field that exceeds it is allocated.
+// By default, the size of a field section is not limited.
+func WithMaxFieldSectionSize(size uint64) DecoderOption {
+	return func(d *Decoder) { d.maxFieldSectionSize = size }
+}
+
+// WithDecoderStream sets the writer that decoder stream instructions are written to.
+// These instructions are only needed if the dynamic table is used.
+func WithDecoderStream(w io.Writer) DecoderOption {
+	return func(d *Decoder) { d.decoderStream = w }
+}
 
 // DecodeFunc is a function that decodes the next header field from a header block.
 // It should be called repeatedly until it returns io.EOF.
 // It returns io.EOF when all header fields have been decoded.
 // Any error other than io.EOF indicates a decoding error.
+// Errors caused by an invalid header block are of type *Error with the code ErrCodeDecompressionFailed,
+// wrapping a *DecodingError that describes where in the header block decoding failed.
 type DecodeFunc func() (HeaderField, error)
 
+// All returns an iterator over the header fields decoded by f.
+// io.EOF is not yielded: iteration ends after the last header field.
+// Any other error is yielded with an empty HeaderField, after which iteration ends.
+func (f DecodeFunc) All() iter.Seq2[HeaderField, error] {
+	return func(yield func(HeaderField, error) bool) {
+		for {
+			hf, err := f()
+			if err == io.EOF {
+				return
+			}
+			if !yield(hf, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
 // NewDecoder returns a new Decoder.
-func NewDecoder() *Decoder {
-	return &Decoder{}
+func NewDecoder(opts ...DecoderOption) *Decoder {
+	d := &Decoder{
+		maxFieldSectionSize: noLimit,
+		blockedStreams:      make(map[uint64]*blockedStream),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// fieldSectionPrefix is the decoded Encoded Field Section Prefix,
+// see Section 4.5.1 of RFC 9204.
+type fieldSectionPrefix struct {
+	requiredInsertCount uint64
+	base                uint64
+}
+
+// noLimit is used as the maximum field section size if the size is not limited.
+const noLimit = math.MaxUint64
+
+// A fieldSection keeps track of the remaining size budget while decoding a field section.
+type fieldSection struct {
+	fieldSectionPrefix
+	remaining uint64
+}
+
+// consume subtracts n bytes from the remaining size budget.
+func (s *fieldSection) consume(n uint64) error {
+	if n > s.remaining {
+		return ErrFieldSectionTooLarge
+	}
+	s.remaining -= n
+	return nil
 }
 
 // Decode returns a function that decodes header fields from the given header block.
 // It does not copy the slice; the caller must ensure it remains valid during decoding.
+//
+// If the header block references the dynamic table, the DecodeFunc returns ErrStreamRequired.
+// When the dynamic table is used, DecodeStream should be used instead.
 func (d *Decoder) Decode(p []byte) DecodeFunc {
-	var readRequiredInsertCount bool
-	var readDeltaBase bool
+	return d.decode(p, 0, false)
+}
+
+// Fields returns an iterator over the header fields of the given header block.
+// It does not copy the slice; the caller must ensure it remains valid during iteration.
+// The errors are the same as the ones returned by the DecodeFunc returned by Decode.
+// Iteration ends after the last header field, or after the first error.
+func (d *Decoder) Fields(p []byte) iter.Seq2[HeaderField, error] {
+	return d.Decode(p).All()
+}
+
+// DecodeAll decodes all header fields of the given header block.
+// If decoding fails, the header fields decoded before the error are returned along with the error.
+func (d *Decoder) DecodeAll(p []byte) ([]HeaderField, error) {
+	return d.DecodeInto(nil, p)
+}
+
+// DecodeInto is like DecodeAll, but it appends the header fields to dst,
+// allowing the caller to reuse a slice across header blocks.
+func (d *Decoder) DecodeInto(dst []HeaderField, p []byte) ([]HeaderField, error) {
+	for hf, err := range d.Fields(p) {
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, hf)
+	}
+	return dst, nil
+}
+
+// DecodeStream returns a function that decodes header fields from the given header block,
+// which was received on the stream with the given stream ID.
+// It does not copy the slice; the caller must ensure it remains valid during decoding.
+//
+// If the header block references dynamic table entries that haven't been received yet,
+// the DecodeFunc returns ErrBlocked, see Unblocked.
+// Once all header fields have been decoded, a Section Acknowledgment is sent on the decoder stream,
+// if the header block references the dynamic table.
+func (d *Decoder) DecodeStream(streamID uint64, p []byte) DecodeFunc {
+	return d.decode(p, streamID, true)
+}
 
+// DecodeContext is like DecodeStream, but if the header block is blocked on the encoder stream,
+// the DecodeFunc waits until the referenced dynamic table entries have been received.
+// If ctx is canceled or its deadline expires while waiting, a Stream Cancellation is sent
+// (see CancelStream), and the DecodeFunc returns the context's error.
+// If CancelStream is called while waiting, the DecodeFunc returns ErrStreamCanceled.
+func (d *Decoder) DecodeContext(ctx context.Context, streamID uint64, p []byte) DecodeFunc {
+	s := &sectionDecoder{
+		d:            d,
+		p:            p,
+		streamID:     streamID,
+		isStream:     true,
+		fieldSection: fieldSection{remaining: d.maxFieldSectionSize},
+	}
+	decodeField := s.decodeFunc()
+	var waitErr error
 	return func() (HeaderField, error) {
-		if !readRequiredInsertCount {
-			requiredInsertCount, rest, err := readVarInt(8, p)
-			if err != nil {
-				return HeaderField{}, err
+		if waitErr != nil {
+			return HeaderField{}, waitErr
+		}
+		for {
+			hf, err := decodeField()
+			if err != ErrBlocked {
+				return hf, err
 			}
-			p = rest
-			readRequiredInsertCount = true
-			if requiredInsertCount != 0 {
-				return HeaderField{}, errors.New("expected Required Insert Count to be zero")
+			select {
+			case <-s.blocked.unblocked:
+				if s.blocked.canceled {
+					waitErr = ErrStreamCanceled
+					return HeaderField{}, waitErr
+				}
+			case <-ctx.Done():
+				if err := d.CancelStream(streamID); err != nil {
+					return HeaderField{}, err
+				}
+				waitErr = ctx.Err()
+				return HeaderField{}, waitErr
 			}
 		}
+	}
+}
 
-		if !readDeltaBase {
-			base, rest, err := readVarInt(7, p)
-			if err != nil {
-				return HeaderField{}, err
-			}
-			p = rest
-			readDeltaBase = true
-			if base != 0 {
-				return HeaderField{}, errors.New("expected Base to be zero")
+func (d *Decoder) decode(p []byte, streamID uint64, isStream bool) DecodeFunc {
+	s := &sectionDecoder{
+		d:            d,
+		p:            p,
+		streamID:     streamID,
+		isStream:     isStream,
+		fieldSection: fieldSection{remaining: d.maxFieldSectionSize},
+	}
+	return s.decodeFunc()
+}
+
+// decodeFunc returns a DecodeFunc that decodes the next header field.
+func (s *sectionDecoder) decodeFunc() DecodeFunc {
+	return func() (HeaderField, error) {
+		var fl fieldLine
+		if err := s.next(&fl); err != nil {
+			return HeaderField{}, err
+		}
+		// Assembling the header field here instead of calling headerField
+		// avoids copying it through memory on this hot path.
+		name, value, err := s.nameValue(&fl)
+		if err != nil {
+			return HeaderField{}, err
+		}
+		return HeaderField{Name: name, Value: value, Sensitive: fl.sensitive}, nil
+	}
+}
+
+// FieldFlags are properties of a field line that are not part of its name and value.
+type FieldFlags uint8
+
+// FieldSensitive is set for field lines that must never be indexed,
+// i.e. the N bit is set, see Section 4.5.4 of RFC 9204.
+// This corresponds to the Sensitive flag of the HeaderField.
+const FieldSensitive FieldFlags = 1 << 0
+
+// DecodeBytes decodes all header fields of the given header block, and calls fn for every header field.
+// Unlike Decode, it doesn't allocate memory for the header fields:
+// name and value point into p for literals that are not Huffman-encoded,
+// to static table entries, or to a scratch buffer that is reused for every header field.
+// They are only valid until fn returns, and they must not be modified.
+// If fn returns an error, decoding is aborted and that error is returned.
+//
+// Like Decode, it returns ErrStreamRequired if the header block references the dynamic table.
+func (d *Decoder) DecodeBytes(p []byte, fn func(name, value []byte, flags FieldFlags) error) error {
+	return d.decodeBytes(p, 0, false, fn)
+}
+
+// DecodeStreamBytes is like DecodeBytes, but for a header block received on the stream with the given stream ID,
+// see DecodeStream.
+// If the header block is blocked on the encoder stream, ErrBlocked is returned before fn is called.
+// DecodeStreamBytes can then be called again once the channel returned by Unblocked is closed.
+func (d *Decoder) DecodeStreamBytes(streamID uint64, p []byte, fn func(name, value []byte, flags FieldFlags) error) error {
+	return d.decodeBytes(p, streamID, true, fn)
+}
+
+func (d *Decoder) decodeBytes(p []byte, streamID uint64, isStream bool, fn func(name, value []byte, flags FieldFlags) error) error {
+	s := sectionDecoder{
+		d:            d,
+		p:            p,
+		streamID:     streamID,
+		isStream:     isStream,
+		fieldSection: fieldSection{remaining: d.maxFieldSectionSize},
+	}
+	scratch := scratchPool.Get().(*scratchBuffer)
+	defer scratchPool.Put(scratch)
+
+	for {
+		var fl fieldLine
+		if err := s.next(&fl); err != nil {
+			if err == io.EOF {
+				return nil
 			}
+			return err
+		}
+		scratch.b = scratch.b[:0]
+		name, value, err := s.fieldBytes(&fl, scratch)
+		if err != nil {
+			return s.fieldLineError(&fl, err)
 		}
+		var flags FieldFlags
+		if fl.sensitive {
+			flags |= FieldSensitive
+		}
+		if err := fn(name, value, flags); err != nil {
+			return err
+		}
+	}
+}
+
+// A sectionDecoder parses the field lines of a field section.
+type sectionDecoder struct {
+	d        *Decoder
+	p        []byte
+	streamID uint64
+	isStream bool
+
+	readPrefix, unblocked, acknowledged bool
+	blocked                             *blockedStream // set while the field section is blocked
+	fieldSection
+	entry HeaderField // the dynamic table entry referenced by the last field line parsed
+
+	offset     int // the number of bytes parsed so far
+	fieldLines int // the number of field lines parsed so far
+}
+
+// next parses the next field line into fl, which must be zero.
+// It returns io.EOF once all field lines have been parsed.
+func (s *sectionDecoder) next(fl *fieldLine) error {
+	if !s.unblocked {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if len(s.p) == 0 {
+		return s.finish()
+	}
+	if err := s.consume(entryOverhead); err != nil {
+		return err
+	}
+	typ := fieldLineType(s.p[0])
+	fl.typ = typ
+	var rest []byte
+	var err error
+	switch typ {
+	case wire.IndexedFieldLine:
+		rest, err = s.parseIndexedHeaderField(fl, s.p)
+	case wire.LiteralFieldLineWithNameReference:
+		rest, err = s.parseLiteralHeaderField(fl, s.p)
+	case wire.LiteralFieldLineWithLiteralName:
+		rest, err = parseLiteralHeaderFieldWithoutNameReference(fl, s.p)
+	case wire.IndexedFieldLinePostBase:
+		rest, err = s.parseIndexedHeaderFieldWithPostBaseIndex(fl, s.p)
+	case wire.LiteralFieldLineWithPostBaseNameReference:
+		rest, err = s.parseLiteralHeaderFieldWithPostBaseNameReference(fl, s.p)
+	}
+	if err != nil {
+		return s.decodingError(s.offset, s.fieldLines, typ, err)
+	}
+	fl.encodedLen = len(s.p) - len(rest)
+	s.p = rest
+	s.offset += fl.encodedLen
+	s.fieldLines++
+	return nil
+}
+
+// start parses the Encoded Field Section Prefix,
+// and checks that the field section is not blocked on the encoder stream.
+func (s *sectionDecoder) start() error {
+	if !s.readPrefix {
+		prefix, rest, err := s.d.parsePrefix(s.p)
+		if err != nil {
+			return s.prefixError(err)
+		}
+		s.fieldSectionPrefix = prefix
+		s.offset += len(s.p) - len(rest)
+		s.p = rest
+		s.readPrefix = true
+	}
 
-		if len(p) == 0 {
-			return HeaderField{}, io.EOF
+	// Field sections that don't reference the dynamic table can't be blocked.
+	if !s.unblocked && s.requiredInsertCount > 0 {
+		if !s.isStream {
+			return ErrStreamRequired
 		}
+		blocked, err := s.d.checkBlocked(s.streamID, s.requiredInsertCount)
+		s.blocked = blocked
+		if err != nil {
+			if err == ErrBlocked {
+				return err
+			}
+			return s.prefixError(err)
+		}
+	}
+	s.unblocked = true
+	return nil
+}
+
+// finish is called after all field lines have been parsed.
+// It acknowledges the field section if necessary, and returns io.EOF.
+func (s *sectionDecoder) finish() error {
+	if s.isStream && !s.acknowledged && s.requiredInsertCount > 0 {
+		return s.acknowledge()
+	}
+	return io.EOF
+}
+
+// acknowledge sends a Section Acknowledgment, and returns io.EOF.
+func (s *sectionDecoder) acknowledge() error {
+	s.acknowledged = true
+	if err := s.d.acknowledgeSection(s.streamID, s.requiredInsertCount); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// fieldLineType returns the field line representation, given the first byte of the field line.
+func fieldLineType(b byte) wire.FieldLineType {
+	switch {
+	case (b & 0x80) > 0: // 1xxxxxxx
+		return wire.IndexedFieldLine
+	case (b & 0xc0) == 0x40: // 01xxxxxx
+		return wire.LiteralFieldLineWithNameReference
+	case (b & 0xe0) == 0x20: // 001xxxxx
+		return wire.LiteralFieldLineWithLiteralName
+	case (b & 0xf0) == 0x10: // 0001xxxx
+		return wire.IndexedFieldLinePostBase
+	default: // 0000xxxx
+		return wire.LiteralFieldLineWithPostBaseNameReference
+	}
+}
 
-		b := p[0]
-		var hf HeaderField
-		var rest []byte
+// headerField decodes a field line into a HeaderField, see nameValue.
+func (s *sectionDecoder) headerField(fl *fieldLine) (HeaderField, error) {
+	name, value, err := s.nameValue(fl)
+	if err != nil {
+		return HeaderField{}, err
+	}
+	return HeaderField{Name: name, Value: value, Sensitive: fl.sensitive}, nil
+}
+
+// nameValue decodes the name and value of a field line.
+// Errors are returned as the error for that field line, see fieldLineError.
+func (s *sectionDecoder) nameValue(fl *fieldLine) (name, value string, _ error) {
+	entry := s.tableEntry(fl)
+	if fl.nameRef {
+		if err := s.consume(uint64(len(entry.Name))); err != nil {
+			return "", "", s.fieldLineError(fl, err)
+		}
+		name = entry.Name
+	} else {
 		var err error
-		switch {
-		case (b & 0x80) > 0: // 1xxxxxxx
-			hf, rest, err = d.parseIndexedHeaderField(p)
-		case (b & 0xc0) == 0x40: // 01xxxxxx
-			hf, rest, err = d.parseLiteralHeaderField(p)
-		case (b & 0xe0) == 0x20: // 001xxxxx
-			hf, rest, err = d.parseLiteralHeaderFieldWithoutNameReference(p)
-		default:
-			err = fmt.Errorf("unexpected type byte: %#x", b)
+		name, err = fl.name.decode(s.maxStringLen())
+		if err != nil {
+			return "", "", s.fieldLineError(fl, err)
 		}
-		p = rest
+		s.remaining -= uint64(len(name))
+	}
+	if fl.indexed {
+		if err := s.consume(uint64(len(entry.Value))); err != nil {
+			return "", "", s.fieldLineError(fl, err)
+		}
+		value = entry.Value
+	} else {
+		var err error
+		value, err = fl.value.decode(s.maxStringLen())
 		if err != nil {
-			return HeaderField{}, err
+			return "", "", s.fieldLineError(fl, err)
 		}
-		return hf, nil
+		s.remaining -= uint64(len(value))
+	}
+	return name, value, nil
+}
+
+// maxStringLen returns the maximum length of the next decoded string literal,
+// or noLimit if the field section size is not limited.
+func (s *sectionDecoder) maxStringLen() uint64 {
+	if s.d.maxFieldSectionSize == noLimit {
+		return noLimit
 	}
+	return s.remaining
 }
 
-func (d *Decoder) parseIndexedHeaderField(buf []byte) (_ HeaderField, rest []byte, _ error) {
-	if buf[0]&0x40 == 0 {
-		return HeaderField{}, buf, errNoDynamicTable
+// fieldBytes decodes the name and value of a field line without allocating.
+// Huffman-encoded literals and dynamic table entries are copied to the scratch buffer.
+func (s *sectionDecoder) fieldBytes(fl *fieldLine, scratch *scratchBuffer) (name, value []byte, _ error) {
+	var err error
+	switch {
+	case !fl.nameRef:
+		name, err = scratch.decode(fl.name, s.remaining)
+	case fl.static:
+		name = staticTableBytes[fl.index].name
+	default:
+		name = scratch.copy(s.entry.Name)
 	}
-	index, rest, err := readVarInt(6, buf)
 	if err != nil {
-		return HeaderField{}, buf, err
+		return nil, nil, err
 	}
-	hf, ok := d.at(index)
-	if !ok {
-		return HeaderField{}, buf, invalidIndexError(index)
+	if err := s.consume(uint64(len(name))); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case !fl.indexed:
+		value, err = scratch.decode(fl.value, s.remaining)
+	case fl.static:
+		value = staticTableBytes[fl.index].value
+	default:
+		value = scratch.copy(s.entry.Value)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
-	return hf, rest, nil
+	if err := s.consume(uint64(len(value))); err != nil {
+		return nil, nil, err
+	}
+	return name, value, nil
 }
 
-func (d *Decoder) parseLiteralHeaderField(buf []byte) (_ HeaderField, rest []byte, _ error) {
-	if buf[0]&0x10 == 0 {
-		return HeaderField{}, buf, errNoDynamicTable
+// decodingError returns an *Error that wraps a *DecodingError.
+func (s *sectionDecoder) decodingError(offset, fieldLine int, typ wire.FieldLineType, err error) error {
+	return &Error{
+		Code: ErrCodeDecompressionFailed,
+		Err:  &DecodingError{Offset: offset, FieldLine: fieldLine, Type: typ, Err: err},
 	}
-	// We don't need to check the value of the N-bit here.
-	// It's only relevant when re-encoding header fields,
-	// and determines whether the header field can be added to the dynamic table.
-	// Since we don't support the dynamic table, we can ignore it.
-	index, rest, err := readVarInt(4, buf)
+}
+
+// prefixError wraps an error that occurred when decoding the Encoded Field Section Prefix.
+func (s *sectionDecoder) prefixError(err error) error {
+	return s.decodingError(0, -1, 0, err)
+}
+
+// fieldLineError wraps an error that occurred when decoding the name or value of fl,
+// which must be the last field line parsed.
+func (s *sectionDecoder) fieldLineError(fl *fieldLine, err error) error {
+	if err == ErrFieldSectionTooLarge {
+		return err
+	}
+	return s.decodingError(s.offset-fl.encodedLen, s.fieldLines-1, fl.typ, err)
+}
+
+func (d *Decoder) parsePrefix(p []byte) (fieldSectionPrefix, []byte, error) {
+	// Fast path for field sections that don't reference the dynamic table.
+	if len(p) >= 2 && p[0] == 0 && p[1] == 0 {
+		return fieldSectionPrefix{}, p[2:], nil
+	}
+	encodedInsertCount, rest, err := wire.ReadVarInt(8, p)
+	if err != nil {
+		return fieldSectionPrefix{}, p, err
+	}
+	if len(rest) == 0 {
+		return fieldSectionPrefix{}, p, io.ErrUnexpectedEOF
+	}
+	negativeDeltaBase := rest[0]&0x80 > 0
+	deltaBase, rest, err := wire.ReadVarInt(7, rest)
+	if err != nil {
+		return fieldSectionPrefix{}, p, err
+	}
+
+	// Field sections that don't reference the dynamic table don't need to lock the table.
+	var insertCount uint64
+	if encodedInsertCount != 0 && d.maxTableCapacity != 0 {
+		d.mutex.Lock()
+		insertCount = d.table.insertCount()
+		d.mutex.Unlock()
+	}
+
+	requiredInsertCount, err := decodeRequiredInsertCount(encodedInsertCount, d.maxTableCapacity, insertCount)
 	if err != nil {
-		return HeaderField{}, buf, err
+		return fieldSectionPrefix{}, p, err
+	}
+	prefix := fieldSectionPrefix{requiredInsertCount: requiredInsertCount}
+	if negativeDeltaBase {
+		if deltaBase >= requiredInsertCount {
+			return fieldSectionPrefix{}, p, errInvalidBase
+		}
+		prefix.base = requiredInsertCount - deltaBase - 1
+	} else {
+		if deltaBase > math.MaxUint64-requiredInsertCount {
+			return fieldSectionPrefix{}, p, errInvalidBase
+		}
+		prefix.base = requiredInsertCount + deltaBase
 	}
-	hf, ok := d.at(index)
+	return prefix, rest, nil
+}
+
+// checkBlocked checks if all dynamic table entries up to the Required Insert Count have been received.
+// If they haven't, the stream is blocked, and the blockedStream is returned along with ErrBlocked.
+func (d *Decoder) checkBlocked(streamID, requiredInsertCount uint64) (*blockedStream, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if requiredInsertCount <= d.table.insertCount() {
+		return nil, nil
+	}
+	s, ok := d.blockedStreams[streamID]
 	if !ok {
-		return HeaderField{}, buf, invalidIndexError(index)
+		if uint64(len(d.blockedStreams)) >= d.maxBlockedStreams {
+			return nil, errTooManyBlockedStreams
+		}
+		s = &blockedStream{
+			requiredInsertCount: requiredInsertCount,
+			unblocked:           make(chan struct{}),
+		}
+		d.blockedStreams[streamID] = s
 	}
-	buf = rest
-	if len(buf) == 0 {
-		return HeaderField{}, buf, io.ErrUnexpectedEOF
+	return s, ErrBlocked
+}
+
+// Unblocked returns a channel that is closed once the header block on the given stream is not blocked
+// on the encoder stream anymore, or when the stream is canceled.
+// If the stream is not blocked, the returned channel is already closed.
+func (d *Decoder) Unblocked(streamID uint64) <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if s, ok := d.blockedStreams[streamID]; ok {
+		return s.unblocked
+	}
+	return closedChan
+}
+
+// decodeRequiredInsertCount reconstructs the Required Insert Count from its encoded form,
+// as described in Section 4.5.1.1 of RFC 9204.
+func decodeRequiredInsertCount(encodedInsertCount, maxTableCapacity, totalInserts uint64) (uint64, error) {
+	if encodedInsertCount == 0 {
+		return 0, nil
+	}
+	maxEntries := maxTableCapacity / entryOverhead
+	fullRange := 2 * maxEntries
+	if encodedInsertCount > fullRange {
+		return 0, errInvalidRequiredInsertCount
+	}
+	maxValue := totalInserts + maxEntries
+	maxWrapped := (maxValue / fullRange) * fullRange
+	requiredInsertCount := maxWrapped + encodedInsertCount - 1
+	if requiredInsertCount > maxValue {
+		if requiredInsertCount <= fullRange {
+			return 0, errInvalidRequiredInsertCount
+		}
+		requiredInsertCount -= fullRange
+	}
+	if requiredInsertCount == 0 {
+		return 0, errInvalidRequiredInsertCount
+	}
+	return requiredInsertCount, nil
+}
+
+// A fieldLine is a parsed field line representation, see Section 4.5 of RFC 9204.
+// String literals are decoded separately, depending on the API used for decoding.
+// A referenced dynamic table entry is copied to the sectionDecoder, keeping the fieldLine small.
+type fieldLine struct {
+	typ         wire.FieldLineType
+	static      bool // the field line references a static table entry
+	nameRef     bool // the name is taken from the entry
+	indexed     bool // both name and value are taken from the entry
+	sensitive   bool
+	index       uint64 // the static index, or the absolute index of the dynamic table entry
+	encodedLen  int    // the length of the field line representation
+	name, value stringLiteral
+}
+
+// tableEntry returns the table entry referenced by fl, which must be the last field line parsed.
+func (s *sectionDecoder) tableEntry(fl *fieldLine) *HeaderField {
+	if fl.static {
+		return &staticTableEntries[fl.index]
+	}
+	return &s.entry
+}
+
+// minDecodedLen returns a lower bound for the length of the decoded name and value of fl,
+// which must be the last field line parsed. It can be used for incomplete field lines.
+func (s *sectionDecoder) minDecodedLen(fl *fieldLine) uint64 {
+	n := fl.name.minDecodedLen() + fl.value.minDecodedLen()
+	if fl.nameRef {
+		n += uint64(len(s.tableEntry(fl).Name))
+	}
+	return n
+}
+
+// lookupEntry sets the table entry referenced by fl, which is either a static table entry,
+// or a dynamic table entry referenced using a relative index.
+func (s *sectionDecoder) lookupEntry(fl *fieldLine, isStatic bool, index uint64) error {
+	// Keep the static table lookup inlinable.
+	if isStatic && index < uint64(len(staticTableEntries)) {
+		fl.static, fl.index = true, index
+		return nil
 	}
-	usesHuffman := buf[0]&0x80 > 0
-	val, rest, err := d.readString(rest, 7, usesHuffman)
+	return s.lookupEntrySlow(fl, isStatic, index)
+}
+
+// lookupEntrySlow handles invalid static table indices, and dynamic table entries.
+func (s *sectionDecoder) lookupEntrySlow(fl *fieldLine, isStatic bool, index uint64) error {
+	if isStatic {
+		return invalidIndexError(index)
+	}
+	hf, err := s.d.atRelative(index, s.fieldSectionPrefix)
 	if err != nil {
-		return HeaderField{}, rest, err
+		return err
 	}
-	hf.Value = val
-	return hf, rest, nil
+	s.entry = hf
+	fl.index = s.base - 1 - index
+	return nil
 }
 
-func (d *Decoder) parseLiteralHeaderFieldWithoutNameReference(buf []byte) (_ HeaderField, rest []byte, _ error) {
-	usesHuffmanForName := buf[0]&0x8 > 0
-	name, rest, err := d.readString(buf, 3, usesHuffmanForName)
+// lookupPostBaseEntry sets the dynamic table entry referenced by fl using a post-base index.
+func (s *sectionDecoder) lookupPostBaseEntry(fl *fieldLine, index uint64) error {
+	hf, err := s.d.atPostBase(index, s.fieldSectionPrefix)
 	if err != nil {
-		return HeaderField{}, rest, err
+		return err
 	}
-	buf = rest
-	if len(buf) == 0 {
-		return HeaderField{}, rest, io.ErrUnexpectedEOF
+	s.entry = hf
+	fl.index = s.base + index
+	return nil
+}
+
+// The following functions parse a field line into fl, and return the remaining data.
+// fl must only have its type set.
+
+func (s *sectionDecoder) parseIndexedHeaderField(fl *fieldLine, buf []byte) (rest []byte, _ error) {
+	isStatic := buf[0]&0x40 > 0
+	index, rest, err := wire.ReadVarInt(6, buf)
+	if err != nil {
+		return buf, err
+	}
+	if err := s.lookupEntry(fl, isStatic, index); err != nil {
+		return buf, err
+	}
+	fl.nameRef, fl.indexed = true, true
+	return rest, nil
+}
+
+func (s *sectionDecoder) parseIndexedHeaderFieldWithPostBaseIndex(fl *fieldLine, buf []byte) (rest []byte, _ error) {
+	index, rest, err := wire.ReadVarInt(4, buf)
+	if err != nil {
+		return buf, err
+	}
+	if err := s.lookupPostBaseEntry(fl, index); err != nil {
+		return buf, err
+	}
+	fl.nameRef, fl.indexed = true, true
+	return rest, nil
+}
+
+func (s *sectionDecoder) parseLiteralHeaderField(fl *fieldLine, buf []byte) (rest []byte, _ error) {
+	isStatic := buf[0]&0x10 > 0
+	// The N-bit is only relevant when re-encoding header fields,
+	// and determines whether the header field can be added to the dynamic table.
+	fl.sensitive = buf[0]&0x20 > 0
+	index, rest, err := wire.ReadVarInt(4, buf)
+	if err != nil {
+		return buf, err
+	}
+	if err := s.lookupEntry(fl, isStatic, index); err != nil {
+		return buf, err
+	}
+	fl.nameRef = true
+	rest, err = fl.value.read(7, rest)
+	if err != nil {
+		return buf, err
+	}
+	return rest, nil
+}
+
+func (s *sectionDecoder) parseLiteralHeaderFieldWithPostBaseNameReference(fl *fieldLine, buf []byte) (rest []byte, _ error) {
+	fl.sensitive = buf[0]&0x8 > 0
+	index, rest, err := wire.ReadVarInt(3, buf)
+	if err != nil {
+		return buf, err
+	}
+	if err := s.lookupPostBaseEntry(fl, index); err != nil {
+		return buf, err
 	}
-	usesHuffmanForVal := buf[0]&0x80 > 0
-	val, rest, err := d.readString(buf, 7, usesHuffmanForVal)
+	fl.nameRef = true
+	rest, err = fl.value.read(7, rest)
 	if err != nil {
-		return HeaderField{}, rest, err
+		return buf, err
 	}
-	return HeaderField{Name: name, Value: val}, rest, nil
+	return rest, nil
 }
 
-func (d *Decoder) readString(buf []byte, n uint8, usesHuffman bool) (string, []byte, error) {
-	l, buf, err := readVarInt(n, buf)
+func parseLiteralHeaderFieldWithoutNameReference(fl *fieldLine, buf []byte) (rest []byte, _ error) {
+	fl.sensitive = buf[0]&0x10 > 0
+	var err error
+	rest, err = fl.name.read(3, buf)
 	if err != nil {
-		return "", nil, err
+		return buf, err
+	}
+	rest, err = fl.value.read(7, rest)
+	if err != nil {
+		return buf, err
+	}
+	return rest, nil
+}
+
+// HandleEncoderStream processes data received on the peer's encoder stream.
+// Instructions don't need to be aligned with the boundaries of p:
+// Incomplete instructions are buffered until the remaining bytes are received.
+// Invalid instructions result in an *Error with the code ErrCodeEncoderStreamError.
+// Any error returned is a connection error.
+func (d *Decoder) HandleEncoderStream(p []byte) error {
+	d.mutex.Lock()
+	increment, err := d.handleEncoderStream(p)
+	if err != nil || increment == 0 {
+		d.mutex.Unlock()
+		return err
 	}
-	if uint64(len(buf)) < l {
-		return "", nil, io.ErrUnexpectedEOF
+	return d.writeInstruction(func(b []byte) []byte { return wire.AppendInsertCountIncrement(b, increment) })
+}
+
+// handleEncoderStream applies the instructions received on the encoder stream.
+// It returns the increment for the Insert Count Increment instruction, or 0 if none needs to be sent.
+// The caller must hold the mutex.
+func (d *Decoder) handleEncoderStream(p []byte) (increment uint64, _ error) {
+	if len(d.encoderStreamBuf) > 0 {
+		d.encoderStreamBuf = append(d.encoderStreamBuf, p...)
+		p = d.encoderStreamBuf
 	}
-	var val string
-	if usesHuffman {
-		val, err = hpack.HuffmanDecodeToString(buf[:l])
+	for len(p) > 0 {
+		rest, err := d.parseEncoderInstruction(p)
+		if err == io.ErrUnexpectedEOF {
+			// A Huffman-encoded string uses at most 30 bits per octet,
+			// so a complete instruction can't be much larger than 4 times the table capacity.
+			if uint64(len(p)) > 4*d.maxTableCapacity+entryOverhead {
+				return 0, &Error{Code: ErrCodeEncoderStreamError, Err: errInstructionTooLarge}
+			}
+			break
+		}
 		if err != nil {
-			return "", nil, err
+			return 0, &Error{Code: ErrCodeEncoderStreamError, Err: err}
 		}
-	} else {
-		val = string(buf[:l])
+		p = rest
+	}
+	d.encoderStreamBuf = append(d.encoderStreamBuf[:0], p...)
+
+	insertCount := d.table.insertCount()
+	for streamID, s := range d.blockedStreams {
+		if s.requiredInsertCount <= insertCount {
+			close(s.unblocked)
+			delete(d.blockedStreams, streamID)
+		}
+	}
+	increment = insertCount - d.knownReceivedCount
+	d.knownReceivedCount = insertCount
+	return increment, nil
+}
+
+// CancelStream sends a Stream Cancellation instruction on the decoder stream.
+// It must be called when a stream is reset, or when reading from a stream is abandoned,
+// before all header blocks on this stream have been decoded.
+// If the stream is blocked, the channel returned by Unblocked is closed.
+func (d *Decoder) CancelStream(streamID uint64) error {
+	d.mutex.Lock()
+	if s, ok := d.blockedStreams[streamID]; ok {
+		s.canceled = true
+		close(s.unblocked)
+		delete(d.blockedStreams, streamID)
+	}
+	// A decoder that doesn't use the dynamic table doesn't need to send Stream Cancellations,
+	// see Section 4.4.2 of RFC 9204.
+	if d.maxTableCapacity == 0 {
+		d.mutex.Unlock()
+		return nil
 	}
-	buf = buf[l:]
-	return val, buf, nil
+	return d.writeInstruction(func(b []byte) []byte { return wire.AppendStreamCancellation(b, streamID) })
 }
 
+func (d *Decoder) acknowledgeSection(streamID, requiredInsertCount uint64) error {
+	d.mutex.Lock()
+	d.knownReceivedCount = max(d.knownReceivedCount, requiredInsertCount)
+	return d.writeInstruction(func(b []byte) []byte { return wire.AppendSectionAcknowledgment(b, streamID) })
+}
+
+// writeInstruction writes an instruction to the decoder stream.
+// The caller must hold the mutex. It is released before writing,
+// so that decoding and HandleEncoderStream aren't blocked by a slow decoder stream.
+func (d *Decoder) writeInstruction(appendInstruction func([]byte) []byte) error {
+	if d.decoderStream == nil {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.writeMutex.Lock()
+	defer d.writeMutex.Unlock()
+	d.instructionBuf = appendInstruction(d.instructionBuf[:0])
+	d.mutex.Unlock()
+	_, err := d.decoderStream.Write(d.instructionBuf)
+	return err
+}
+
+// parseEncoderInstruction parses and applies a single encoder instruction,
+// see Section 4.3 of RFC 9204.
+// It returns io.ErrUnexpectedEOF if p doesn't contain the complete instruction,
+// in which case the dynamic table is not modified.
+func (d *Decoder) parseEncoderInstruction(p []byte) (rest []byte, _ error) {
+	b := p[0]
+	switch {
+	case b&0x80 > 0: // 1Txxxxxx: Insert with Name Reference
+		isStatic := b&0x40 > 0
+		index, rest, err := wire.ReadVarInt(6, p)
+		if err != nil {
+			return p, err
+		}
+		var name string
+		if isStatic {
+			hf, ok := d.at(index)
+			if !ok {
+				return p, invalidIndexError(index)
+			}
+			name = hf.Name
+		} else {
+			hf, err := d.atInsertRelative(index)
+			if err != nil {
+				return p, err
+			}
+			name = hf.Name
+		}
+		if len(rest) == 0 {
+			return p, io.ErrUnexpectedEOF
+		}
+		value, rest, err := readString(7, rest)
+		if err != nil {
+			return p, err
+		}
+		return rest, d.table.insert(HeaderField{Name: name, Value: value})
+	case b&0x40 > 0: // 01Hxxxxx: Insert with Literal Name
+		name, rest, err := readString(5, p)
+		if err != nil {
+			return p, err
+		}
+		if len(rest) == 0 {
+			return p, io.ErrUnexpectedEOF
+		}
+		value, rest, err := readString(7, rest)
+		if err != nil {
+			return p, err
+		}
+		return rest, d.table.insert(HeaderField{Name: name, Value: value})
+	case b&0x20 > 0: // 001xxxxx: Set Dynamic Table Capacity
+		capacity, rest, err := wire.ReadVarInt(5, p)
+		if err != nil {
+			return p, err
+		}
+		if capacity > d.maxTableCapacity {
+			return p, errCapacityExceeded
+		}
+		d.table.setCapacity(capacity)
+		return rest, nil
+	default: // 000xxxxx: Duplicate
+		index, rest, err := wire.ReadVarInt(5, p)
+		if err != nil {
+			return p, err
+		}
+		hf, err := d.atInsertRelative(index)
+		if err != nil {
+			return p, err
+		}
+		return rest, d.table.insert(hf)
+	}
+}
+
+// atInsertRelative returns the dynamic table entry referenced by a relative index in an encoder instruction.
+// Relative indices in encoder instructions are relative to the Insert Count.
+func (d *Decoder) atInsertRelative(index uint64) (HeaderField, error) {
+	insertCount := d.table.insertCount()
+	if index >= insertCount {
+		return HeaderField{}, errInvalidDynamicIndex
+	}
+	hf, ok := d.table.get(insertCount - 1 - index)
+	if !ok {
+		return HeaderField{}, errInvalidDynamicIndex
+	}
+	return hf, nil
+}
+
+// A stringLiteral is a string literal that hasn't been decoded yet, see Section 4.1.2 of RFC 9204.
+type stringLiteral struct {
+	data    []byte
+	huffman bool
+	// pending is the number of bytes that haven't been received yet.
+	// It is only set if read returns io.ErrUnexpectedEOF.
+	pending uint64
+}
+
+// read reads a string literal with an n-bit prefix into l, which must be zero.
+// The Huffman flag is the bit preceding the prefix.
+// If the string literal is incomplete, l contains the data received so far,
+// and the number of missing bytes.
+// Reading into l instead of returning a stringLiteral avoids copying it on the hot path.
+func (l *stringLiteral) read(n uint8, buf []byte) (rest []byte, _ error) {
+	if len(buf) == 0 {
+		return buf, io.ErrUnexpectedEOF
+	}
+	l.huffman = buf[0]&(1<<n) > 0
+	length, rest, err := wire.ReadVarInt(n, buf)
+	if err != nil {
+		return buf, err
+	}
+	if uint64(len(rest)) < length {
+		l.data, l.pending = rest, length-uint64(len(rest))
+		return buf, io.ErrUnexpectedEOF
+	}
+	l.data = rest[:length]
+	return rest[length:], nil
+}
+
+// readString reads and decodes a string literal with an n-bit prefix.
+func readString(n uint8, buf []byte) (string, []byte, error) {
+	var l stringLiteral
+	rest, err := l.read(n, buf)
+	if err != nil {
+		return "", buf, err
+	}
+	s, err := l.decode(noLimit)
+	if err != nil {
+		return "", buf, err
+	}
+	return s, rest, nil
+}
+
+// minDecodedLen returns a lower bound for the length of the decoded string.
+func (l stringLiteral) minDecodedLen() uint64 {
+	n := uint64(len(l.data)) + l.pending
+	if !l.huffman {
+		return n
+	}
+	// The longest Huffman code is 30 bits long.
+	return n * 8 / 30
+}
+
+// decode decodes the string literal.
+// If the decoded string is longer than maxLen, ErrFieldSectionTooLarge is returned.
+// Raw strings are checked before they are allocated, and Huffman-encoded strings
+// are not decoded if their minimum decoded length exceeds maxLen.
+func (l stringLiteral) decode(maxLen uint64) (string, error) {
+	if l.minDecodedLen() > maxLen {
+		return "", ErrFieldSectionTooLarge
+	}
+	if !l.huffman {
+		return string(l.data), nil
+	}
+	if maxLen == noLimit {
+		return hpack.HuffmanDecodeToString(l.data)
+	}
+	w := &limitedStringWriter{limit: maxLen}
+	if _, err := hpack.HuffmanDecode(w, l.data); err != nil {
+		return "", err
+	}
+	return w.s, nil
+}
+
+// The limitedStringWriter converts the bytes written to a string,
+// unless their length exceeds the limit.
+type limitedStringWriter struct {
+	s     string
+	limit uint64
+}
+
+func (w *limitedStringWriter) Write(p []byte) (int, error) {
+	if uint64(len(p)) > w.limit {
+		return 0, ErrFieldSectionTooLarge
+	}
+	w.s = string(p)
+	return len(p), nil
+}
+
+// A scratchBuffer holds decoded Huffman strings and copies of dynamic table entries for DecodeBytes.
+type scratchBuffer struct {
+	b     []byte
+	limit uint64
+}
+
+var scratchPool = sync.Pool{New: func() any { return &scratchBuffer{} }}
+
+// decode returns the decoded string literal.
+// Raw strings are returned as is, and Huffman-encoded strings are decoded into the scratch buffer.
+// If the decoded string is longer than maxLen, ErrFieldSectionTooLarge is returned.
+func (b *scratchBuffer) decode(l stringLiteral, maxLen uint64) ([]byte, error) {
+	if l.minDecodedLen() > maxLen {
+		return nil, ErrFieldSectionTooLarge
+	}
+	if !l.huffman {
+		return l.data, nil
+	}
+	offset := len(b.b)
+	b.limit = maxLen
+	if _, err := hpack.HuffmanDecode(b, l.data); err != nil {
+		return nil, err
+	}
+	return b.b[offset:], nil
+}
+
+// copy copies s into the scratch buffer.
+func (b *scratchBuffer) copy(s string) []byte {
+	offset := len(b.b)
+	b.b = append(b.b, s...)
+	return b.b[offset:]
+}
+
+func (b *scratchBuffer) Write(p []byte) (int, error) {
+	if uint64(len(p)) > b.limit {
+		return 0, ErrFieldSectionTooLarge
+	}
+	b.b = append(b.b, p...)
+	return len(p), nil
+}
+
+// staticTableBytes contains the names and values of the static table entries as byte slices,
+// so that DecodeBytes doesn't need to allocate for them.
+var staticTableBytes = func() (t [len(staticTableEntries)]struct{ name, value []byte }) {
+	for i, hf := range staticTableEntries {
+		t[i].name = []byte(hf.Name)
+		t[i].value = []byte(hf.Value)
+	}
+	return t
+}()
+
 func (d *Decoder) at(i uint64) (hf HeaderField, ok bool) {
 	if i >= uint64(len(staticTableEntries)) {
 		return
 	}
 	return staticTableEntries[i], true
 }
+
+// atRelative returns the dynamic table entry referenced by a relative index in a field line.
+func (d *Decoder) atRelative(index uint64, prefix fieldSectionPrefix) (HeaderField, error) {
+	if index >= prefix.base {
+		return HeaderField{}, errInvalidDynamicIndex
+	}
+	return d.atDynamic(prefix.base-1-index, prefix)
+}
+
+// atPostBase returns the dynamic table entry referenced by a post-base index in a field line.
+func (d *Decoder) atPostBase(index uint64, prefix fieldSectionPrefix) (HeaderField, error) {
+	if index >= prefix.requiredInsertCount || prefix.base >= prefix.requiredInsertCount-index {
+		return HeaderField{}, errInvalidDynamicIndex
+	}
+	return d.atDynamic(prefix.base+index, prefix)
+}
+
+func (d *Decoder) atDynamic(absIndex uint64, prefix fieldSectionPrefix) (HeaderField, error) {
+	if absIndex >= prefix.requiredInsertCount {
+		return HeaderField{}, errInvalidDynamicIndex
+	}
+	d.mutex.Lock()
+	hf, ok := d.table.get(absIndex)
+	d.mutex.Unlock()
+	if !ok {
+		return HeaderField{}, errInvalidDynamicIndex
+	}
+	return hf, nil
+}
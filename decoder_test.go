@@ -1,17 +1,24 @@
 package qpack
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/quic-go/qpack/wire"
 	"golang.org/x/net/http2/hpack"
 
 	"github.com/stretchr/testify/require"
 )
 
 func insertPrefix(data []byte) []byte {
-	prefix := appendVarInt(nil, 8, 0)
-	prefix = appendVarInt(prefix, 7, 0)
+	prefix := wire.AppendVarInt(nil, 8, 0)
+	prefix = wire.AppendVarInt(prefix, 7, 0)
 	return append(prefix, data...)
 }
 
@@ -22,19 +29,24 @@ func TestDecoderInvalidInputs(t *testing.T) {
 		expected string
 	}{
 		{
-			name:     "non-zero required insert count", // we don't support dynamic table updates
-			input:    append(appendVarInt(nil, 8, 1), appendVarInt(nil, 7, 0)...),
-			expected: "expected Required Insert Count to be zero",
+			name:     "non-zero required insert count without dynamic table",
+			input:    append(wire.AppendVarInt(nil, 8, 1), wire.AppendVarInt(nil, 7, 0)...),
+			expected: "invalid Required Insert Count",
 		},
 		{
-			name:     "non-zero delta base", // we don't support dynamic table updates
-			input:    append(appendVarInt(nil, 8, 0), appendVarInt(nil, 7, 1)...),
-			expected: "expected Base to be zero",
+			name:     "negative base",
+			input:    append(wire.AppendVarInt(nil, 8, 0), 0x80),
+			expected: "invalid Base",
 		},
 		{
-			name:     "unknown type byte",
+			name:     "post-base index without dynamic table",
 			input:    insertPrefix([]byte{0x10}),
-			expected: "unexpected type byte: 0x10",
+			expected: "invalid dynamic table index",
+		},
+		{
+			name:     "post-base name reference without dynamic table",
+			input:    insertPrefix([]byte{0x00, 0x00}),
+			expected: "invalid dynamic table index",
 		},
 	}
 
@@ -43,7 +55,12 @@ func TestDecoderInvalidInputs(t *testing.T) {
 			dec := NewDecoder()
 			decode := dec.Decode(tt.input)
 			_, err := decode()
-			require.EqualError(t, err, tt.expected)
+			var qerr *Error
+			require.ErrorAs(t, err, &qerr)
+			require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+			var derr *DecodingError
+			require.ErrorAs(t, err, &derr)
+			require.EqualError(t, derr.Err, tt.expected)
 		})
 	}
 }
@@ -61,15 +78,15 @@ type testcase struct {
 var (
 	literalFieldWithoutNameReference = testcase{
 		Data: func() []byte {
-			data := appendVarInt(nil, 3, 3)
+			data := wire.AppendVarInt(nil, 3, 3)
 			data[0] ^= 0x20
 			data = append(data, []byte("foo")...)
-			data = appendVarInt(data, 7, uint64(len(loremIpsum1)))
+			data = wire.AppendVarInt(data, 7, uint64(len(loremIpsum1)))
 			data = append(data, []byte(loremIpsum1)...)
-			data2 := appendVarInt(nil, 3, 3)
+			data2 := wire.AppendVarInt(nil, 3, 3)
 			data2[0] ^= 0x20
 			data2 = append(data2, []byte("bar")...)
-			data2 = appendVarInt(data2, 7, uint64(len(loremIpsum2)))
+			data2 = wire.AppendVarInt(data2, 7, uint64(len(loremIpsum2)))
 			data2 = append(data2, []byte(loremIpsum2)...)
 			return insertPrefix(append(data, data2...))
 		}(),
@@ -80,32 +97,32 @@ var (
 	}
 	literalFieldWithNameReference = testcase{
 		Data: func() []byte {
-			data := appendVarInt(nil, 4, 49)
+			data := wire.AppendVarInt(nil, 4, 49)
 			data[0] ^= 0x40 | 0x10
-			data = appendVarInt(data, 7, uint64(len(loremIpsum1)))
+			data = wire.AppendVarInt(data, 7, uint64(len(loremIpsum1)))
 			data = append(data, []byte(loremIpsum1)...)
-			data2 := appendVarInt(nil, 4, 82)
+			data2 := wire.AppendVarInt(nil, 4, 82)
 			data2[0] ^= 0x40 | 0x10
 			data2[0] |= 0x20 // set the N-bit
-			data2 = appendVarInt(data2, 7, uint64(len(loremIpsum2)))
+			data2 = wire.AppendVarInt(data2, 7, uint64(len(loremIpsum2)))
 			data2 = append(data2, []byte(loremIpsum2)...)
 			return insertPrefix(append(data, data2...))
 		}(),
 		Expected: []HeaderField{
 			{Name: "content-type", Value: loremIpsum1},
-			{Name: "access-control-request-method", Value: loremIpsum2},
+			{Name: "access-control-request-method", Value: loremIpsum2, Sensitive: true},
 		},
 	}
 	literalFieldWithHuffmanEncoding = testcase{
 		Data: func() []byte {
-			data := appendVarInt(nil, 4, 49)
+			data := wire.AppendVarInt(nil, 4, 49)
 			data[0] ^= 0x40 | 0x10
-			data2 := appendVarInt(nil, 7, hpack.HuffmanEncodeLength(loremIpsum1))
+			data2 := wire.AppendVarInt(nil, 7, hpack.HuffmanEncodeLength(loremIpsum1))
 			data2[0] ^= 0x80
 			data = hpack.AppendHuffmanString(append(data, data2...), loremIpsum1)
-			data3 := appendVarInt(nil, 4, 82)
+			data3 := wire.AppendVarInt(nil, 4, 82)
 			data3[0] ^= 0x40 | 0x10
-			data4 := appendVarInt(nil, 7, hpack.HuffmanEncodeLength(loremIpsum2))
+			data4 := wire.AppendVarInt(nil, 7, hpack.HuffmanEncodeLength(loremIpsum2))
 			data4[0] ^= 0x80
 			data5 := hpack.AppendHuffmanString(append(data3, data4...), loremIpsum2)
 			return insertPrefix(append(data, data5...))
@@ -117,9 +134,9 @@ var (
 	}
 	indexedField = testcase{
 		Data: func() []byte {
-			data := appendVarInt(nil, 6, 20)
+			data := wire.AppendVarInt(nil, 6, 20)
 			data[0] ^= 0x80 | 0x40
-			data2 := appendVarInt(nil, 6, 42)
+			data2 := wire.AppendVarInt(nil, 6, 42)
 			data2[0] ^= 0x80 | 0x40
 			return insertPrefix(append(data, data2...))
 		}(),
@@ -130,15 +147,72 @@ var (
 	}
 )
 
+func TestDecoderInvalidHuffmanEncoding(t *testing.T) {
+	data := wire.AppendVarInt(nil, 4, 49)
+	data[0] ^= 0x40 | 0x10
+	data = wire.AppendVarInt(data, 7, 1)
+	data[len(data)-1] ^= 0x80 // Huffman encoded
+	data = append(data, 0xff) // invalid padding
+	_, err := NewDecoder().Decode(insertPrefix(data))()
+	require.ErrorIs(t, err, hpack.ErrInvalidHuffman)
+	var qerr *Error
+	require.ErrorAs(t, err, &qerr)
+	require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+}
+
+func TestDecoderErrorPosition(t *testing.T) {
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{})
+	data = wire.AppendIndexedFieldLine(data, true, 17)
+	data = wire.AppendLiteralFieldLineWithLiteralName(data, false, "foo", false, "bar", false)
+	offset := len(data)
+
+	t.Run("invalid Huffman encoding", func(t *testing.T) {
+		// Huffman-encoded, invalid padding
+		data := append(wire.AppendLiteralFieldLineWithNameReference(slices.Clone(data), false, true, 1, "", false), 0xff)
+		data[len(data)-2] = 0x80 | 1
+		decode := NewDecoder().Decode(data)
+		for range 2 {
+			_, err := decode()
+			require.NoError(t, err)
+		}
+		_, err := decode()
+		require.ErrorIs(t, err, hpack.ErrInvalidHuffman)
+		var derr *DecodingError
+		require.ErrorAs(t, err, &derr)
+		require.Equal(t, offset, derr.Offset)
+		require.Equal(t, 2, derr.FieldLine)
+		require.Equal(t, wire.LiteralFieldLineWithNameReference, derr.Type)
+		require.EqualError(t, err, "QPACK_DECOMPRESSION_FAILED: field line 2 (Literal Field Line with Name Reference) at offset 11: "+hpack.ErrInvalidHuffman.Error())
+	})
+
+	t.Run("truncated field line", func(t *testing.T) {
+		data := wire.AppendLiteralFieldLineWithNameReference(slices.Clone(data), false, true, 1, "foobar", false)
+		err := NewDecoder().DecodeBytes(data[:len(data)-1], func(_, _ []byte, _ FieldFlags) error { return nil })
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		var derr *DecodingError
+		require.ErrorAs(t, err, &derr)
+		require.Equal(t, &DecodingError{Offset: offset, FieldLine: 2, Type: wire.LiteralFieldLineWithNameReference, Err: io.ErrUnexpectedEOF}, derr)
+	})
+
+	t.Run("invalid prefix", func(t *testing.T) {
+		_, err := NewDecoder().Decode([]byte{0x01, 0x00})()
+		require.ErrorIs(t, err, errInvalidRequiredInsertCount)
+		var derr *DecodingError
+		require.ErrorAs(t, err, &derr)
+		require.Equal(t, &DecodingError{FieldLine: -1, Err: errInvalidRequiredInsertCount}, derr)
+		require.EqualError(t, err, "QPACK_DECOMPRESSION_FAILED: Encoded Field Section Prefix: invalid Required Insert Count")
+	})
+}
+
 func TestDecoderLiteralHeaderFieldDynamicTable(t *testing.T) {
-	data := appendVarInt(nil, 4, 49)
+	data := wire.AppendVarInt(nil, 4, 49)
 	data[0] ^= 0x40 // don't set the static flag (0x10)
-	data = appendVarInt(data, 7, 6)
+	data = wire.AppendVarInt(data, 7, 6)
 	data = append(data, []byte("foobar")...)
 	dec := NewDecoder()
 	decode := dec.Decode(insertPrefix(data))
 	_, err := decode()
-	require.ErrorIs(t, err, errNoDynamicTable)
+	require.ErrorIs(t, err, errInvalidDynamicIndex)
 }
 
 func decodeAll(t *testing.T, decode func() (HeaderField, error)) []HeaderField {
@@ -155,6 +229,74 @@ func decodeAll(t *testing.T, decode func() (HeaderField, error)) []HeaderField {
 	return hfs
 }
 
+func TestDecoderFields(t *testing.T) {
+	dec := NewDecoder()
+	var hfs []HeaderField
+	for hf, err := range dec.Fields(literalFieldWithNameReference.Data) {
+		require.NoError(t, err)
+		hfs = append(hfs, hf)
+	}
+	require.Equal(t, literalFieldWithNameReference.Expected, hfs)
+
+	t.Run("stopping early", func(t *testing.T) {
+		var count int
+		for range dec.Fields(literalFieldWithNameReference.Data) {
+			count++
+			break
+		}
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		// the first field line is valid, the second one isn't
+		data := append(indexedField.Data[:3:3], 0x80|0x40|0x3f, 0xff) // incomplete varint
+		var fields []HeaderField
+		var errs []error
+		for hf, err := range dec.Fields(data) {
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fields = append(fields, hf)
+		}
+		require.Equal(t, indexedField.Expected[:1], fields)
+		require.Len(t, errs, 1)
+		var qerr *Error
+		require.ErrorAs(t, errs[0], &qerr)
+		require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+		require.ErrorIs(t, qerr, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestDecoderFieldsBlocked(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(200), WithMaxBlockedStreams(1))
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 2}) // Required Insert Count = 1
+	data = append(data, 0x80)
+	var errs []error
+	for _, err := range dec.DecodeStream(4, data).All() {
+		errs = append(errs, err)
+	}
+	require.Equal(t, []error{ErrBlocked}, errs)
+}
+
+func TestDecoderDecodeAll(t *testing.T) {
+	dec := NewDecoder()
+	hfs, err := dec.DecodeAll(literalFieldWithHuffmanEncoding.Data)
+	require.NoError(t, err)
+	require.Equal(t, literalFieldWithHuffmanEncoding.Expected, hfs)
+
+	// the header fields are appended to the slice
+	hfs, err = dec.DecodeInto(hfs[:1], indexedField.Data)
+	require.NoError(t, err)
+	require.Equal(t, append(literalFieldWithHuffmanEncoding.Expected[:1:1], indexedField.Expected...), hfs)
+
+	// the header fields decoded before the error are returned
+	data := append(indexedField.Data[:3:3], 0x80|0x40|0x3f, 0xff) // incomplete varint
+	hfs, err = dec.DecodeInto(hfs[:0], data)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, indexedField.Expected[:1], hfs)
+}
+
 func TestDecoderIndexedHeaderFields(t *testing.T) {
 	dec := NewDecoder()
 	decodeFn := dec.Decode(indexedField.Data)
@@ -170,7 +312,7 @@ func TestDecoderInvalidIndexedHeaderFields(t *testing.T) {
 		{
 			name: "errors when a non-existent static table entry is referenced",
 			input: func() []byte {
-				data := appendVarInt(nil, 6, 10000)
+				data := wire.AppendVarInt(nil, 6, 10000)
 				data[0] ^= 0x80 | 0x40
 				return insertPrefix(data)
 			}(),
@@ -179,11 +321,11 @@ func TestDecoderInvalidIndexedHeaderFields(t *testing.T) {
 		{
 			name: "rejects an indexed header field that references the dynamic table",
 			input: func() []byte {
-				data := appendVarInt(nil, 6, 20)
+				data := wire.AppendVarInt(nil, 6, 20)
 				data[0] ^= 0x80 // don't set the static flag (0x40)
 				return insertPrefix(data)
 			}(),
-			expected: errNoDynamicTable.Error(),
+			expected: errInvalidDynamicIndex.Error(),
 		},
 	}
 
@@ -192,7 +334,12 @@ func TestDecoderInvalidIndexedHeaderFields(t *testing.T) {
 			dec := NewDecoder()
 			decodeFn := dec.Decode(tt.input)
 			_, err := decodeFn()
-			require.EqualError(t, err, tt.expected)
+			var qerr *Error
+			require.ErrorAs(t, err, &qerr)
+			require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+			var derr *DecodingError
+			require.ErrorAs(t, err, &derr)
+			require.EqualError(t, derr.Err, tt.expected)
 		})
 	}
 }
@@ -203,6 +350,133 @@ func TestDecoderLiteralHeaderFieldWithNameReferenceAndHuffmanEncoding(t *testing
 	require.Equal(t, literalFieldWithHuffmanEncoding.Expected, decodeAll(t, decodeFn))
 }
 
+func TestDecoderLiteralHeaderFieldWithNameReference(t *testing.T) {
+	dec := NewDecoder()
+	decodeFn := dec.Decode(literalFieldWithNameReference.Data)
+	require.Equal(t, literalFieldWithNameReference.Expected, decodeAll(t, decodeFn))
+}
+
+func TestDecoderSensitiveHeaderFields(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(200))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 1
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 2, Sign: true}) // Base = 0
+	// literal field line with static name reference
+	data = append(data, 0x40|0x20|0x10|6, 0x03)
+	data = append(data, "sun"...)
+	// literal field line with post-base name reference
+	data = append(data, 0x08|0, 0x03)
+	data = append(data, "baz"...)
+	// literal field line with literal name
+	data = append(data, 0x20|0x10|0x03)
+	data = append(data, "abc"...)
+	data = append(data, 0x03)
+	data = append(data, "def"...)
+	// the same representations without the N-bit
+	data = append(data, 0x40|0x10|6, 0x03)
+	data = append(data, "sun"...)
+	data = append(data, 0x00|0, 0x03)
+	data = append(data, "baz"...)
+	data = append(data, 0x20|0x03)
+	data = append(data, "abc"...)
+	data = append(data, 0x03)
+	data = append(data, "def"...)
+
+	require.Equal(t,
+		[]HeaderField{
+			{Name: "date", Value: "sun", Sensitive: true},
+			{Name: "foo", Value: "baz", Sensitive: true},
+			{Name: "abc", Value: "def", Sensitive: true},
+			{Name: "date", Value: "sun"},
+			{Name: "foo", Value: "baz"},
+			{Name: "abc", Value: "def"},
+		},
+		decodeAll(t, dec.DecodeStream(0, data)),
+	)
+}
+
+func TestDecoderMaxFieldSectionSize(t *testing.T) {
+	// :method: GET (static index 17), 32 + 7 + 3 bytes
+	indexed := wire.AppendVarInt(nil, 6, 17)
+	indexed[0] |= 0x80 | 0x40
+	// date: <value>, with a static name reference (static index 6)
+	literal := func(value string) []byte {
+		b := wire.AppendVarInt(nil, 4, 6)
+		b[0] |= 0x40 | 0x10
+		b = wire.AppendVarInt(b, 7, uint64(len(value)))
+		return append(b, value...)
+	}
+	huffmanLiteral := func(value string) []byte {
+		b := wire.AppendVarInt(nil, 4, 6)
+		b[0] |= 0x40 | 0x10
+		b = wire.AppendVarInt(b, 7, hpack.HuffmanEncodeLength(value))
+		b[1] |= 0x80
+		return hpack.AppendHuffmanString(b, value)
+	}
+	// foo: <value>, with a literal name
+	literalName := func(value string) []byte {
+		b := wire.AppendVarInt(nil, 3, 3)
+		b[0] |= 0x20
+		b = append(b, "foo"...)
+		b = wire.AppendVarInt(b, 7, uint64(len(value)))
+		return append(b, value...)
+	}
+
+	value := strings.Repeat("a", 100)
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		size  uint64 // the size of the field section
+		count int    // the number of fields
+	}{
+		{name: "indexed", data: indexed, size: 42, count: 1},
+		{name: "literal", data: literal(value), size: 136, count: 1},
+		{name: "Huffman-encoded literal", data: huffmanLiteral(value), size: 136, count: 1},
+		{name: "literal name", data: literalName(value), size: 135, count: 1},
+		{name: "multiple fields", data: append(append(indexed, literal(value)...), indexed...), size: 220, count: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Run("at the limit", func(t *testing.T) {
+				dec := NewDecoder(WithMaxFieldSectionSize(tc.size))
+				require.Len(t, decodeAll(t, dec.Decode(insertPrefix(tc.data))), tc.count)
+			})
+
+			t.Run("exceeding the limit", func(t *testing.T) {
+				dec := NewDecoder(WithMaxFieldSectionSize(tc.size - 1))
+				decode := dec.Decode(insertPrefix(tc.data))
+				for i := 0; i < tc.count-1; i++ {
+					_, err := decode()
+					require.NoError(t, err)
+				}
+				_, err := decode()
+				require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+				var qerr *Error
+				require.False(t, errors.As(err, &qerr))
+			})
+		})
+	}
+}
+
+func TestDecoderMaxFieldSectionSizeHuffmanLowerBound(t *testing.T) {
+	// A Huffman-encoded string of 400 bytes decodes to at least 400*8/30 = 106 bytes,
+	// so it is rejected without decoding it.
+	// This string is invalid, and decoding it would result in a Huffman decoding error.
+	b := wire.AppendVarInt(nil, 4, 6)
+	b[0] |= 0x40 | 0x10
+	b = wire.AppendVarInt(b, 7, 400)
+	b[1] |= 0x80
+	b = append(b, bytes.Repeat([]byte{0xff}, 400)...)
+
+	_, err := NewDecoder(WithMaxFieldSectionSize(32 + 4 + 105)).Decode(insertPrefix(b))()
+	require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+	_, err = NewDecoder(WithMaxFieldSectionSize(32 + 4 + 106)).Decode(insertPrefix(b))()
+	require.ErrorIs(t, err, hpack.ErrInvalidHuffman)
+}
+
 func TestDecoderLiteralHeaderFieldWithoutNameReference(t *testing.T) {
 	dec := NewDecoder()
 	decodeFn := dec.Decode(literalFieldWithoutNameReference.Data)
@@ -254,6 +528,9 @@ func testDecoderEOF(t *testing.T, data []byte, numExpected int) {
 			}
 			if err != nil {
 				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				var qerr *Error
+				require.ErrorAs(t, err, &qerr)
+				require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
 				break
 			}
 			hfs = append(hfs, hf)
@@ -291,6 +568,57 @@ func BenchmarkDecoder(b *testing.B) {
 	})
 }
 
+func BenchmarkDecoderDecodeBytes(b *testing.B) {
+	b.Run("literal field without name reference", func(b *testing.B) {
+		benchmarkDecoderDecodeBytes(b,
+			literalFieldWithoutNameReference.Data,
+			len(literalFieldWithoutNameReference.Expected),
+		)
+	})
+
+	b.Run("literal field with name reference", func(b *testing.B) {
+		benchmarkDecoderDecodeBytes(b,
+			literalFieldWithNameReference.Data,
+			len(literalFieldWithNameReference.Expected),
+		)
+	})
+
+	b.Run("literal field with Huffman encoding", func(b *testing.B) {
+		benchmarkDecoderDecodeBytes(b,
+			literalFieldWithHuffmanEncoding.Data,
+			len(literalFieldWithHuffmanEncoding.Expected),
+		)
+	})
+
+	b.Run("indexed field", func(b *testing.B) {
+		benchmarkDecoderDecodeBytes(b,
+			indexedField.Data,
+			len(indexedField.Expected),
+		)
+	})
+}
+
+func benchmarkDecoderDecodeBytes(b *testing.B, data []byte, numExpected int) {
+	b.ReportAllocs()
+
+	decoder := NewDecoder()
+	var numFields, size int
+	fn := func(name, value []byte, _ FieldFlags) error {
+		numFields++
+		size += len(name) + len(value)
+		return nil
+	}
+	for b.Loop() {
+		numFields = 0
+		if err := decoder.DecodeBytes(data, fn); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		if numFields != numExpected {
+			b.Fatalf("expected %d header fields, got %d", numExpected, numFields)
+		}
+	}
+}
+
 func benchmarkDecoder(b *testing.B, data []byte, numExpected int) {
 	b.ReportAllocs()
 
@@ -316,3 +644,712 @@ func benchmarkDecoder(b *testing.B, data []byte, numExpected int) {
 		clear(hdr)
 	}
 }
+
+// helpers to build encoder stream instructions, see Section 4.3 of RFC 9204
+
+func decodeBytesAll(t *testing.T, dec *Decoder, p []byte) []HeaderField {
+	t.Helper()
+	var hfs []HeaderField
+	require.NoError(t, dec.DecodeBytes(p, func(name, value []byte, flags FieldFlags) error {
+		hfs = append(hfs, HeaderField{
+			Name:      string(name),
+			Value:     string(value),
+			Sensitive: flags&FieldSensitive > 0,
+		})
+		return nil
+	}))
+	return hfs
+}
+
+func TestDecoderDecodeBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		testcase
+	}{
+		{name: "literal field without name reference", testcase: literalFieldWithoutNameReference},
+		{name: "literal field with name reference", testcase: literalFieldWithNameReference},
+		{name: "literal field with Huffman encoding", testcase: literalFieldWithHuffmanEncoding},
+		{name: "indexed field", testcase: indexedField},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, decodeBytesAll(t, NewDecoder(), tc.Data))
+		})
+	}
+}
+
+func TestDecoderDecodeBytesDynamicTable(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(200))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)
+	encoderStream = wire.AppendInsertWithNameReference(encoderStream, true, 6, "sun", false) // date
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 2
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 3, Sign: true}) // Base = 1
+	// indexed field line referencing the dynamic table
+	data = append(data, 0x80|0)
+	// indexed field line with post-base index
+	data = append(data, 0x10|0)
+	// literal field line with dynamic name reference, Huffman-encoded, with the N-bit
+	data = append(data, 0x40|0x20|0)
+	data = wire.AppendVarInt(data, 7, hpack.HuffmanEncodeLength("baz"))
+	data[len(data)-1] |= 0x80
+	data = hpack.AppendHuffmanString(data, "baz")
+	// literal field line with post-base name reference
+	data = append(data, 0x00|0, 0x03)
+	data = append(data, "mon"...)
+	// literal field line with literal name, Huffman-encoded
+	data = append(data, 0x20|0x08|byte(hpack.HuffmanEncodeLength("lorem")))
+	data = hpack.AppendHuffmanString(data, "lorem")
+	data = wire.AppendVarInt(data, 7, hpack.HuffmanEncodeLength("ipsum"))
+	data[len(data)-1] |= 0x80
+	data = hpack.AppendHuffmanString(data, "ipsum")
+
+	expected := []HeaderField{
+		{Name: "foo", Value: "bar"},
+		{Name: "date", Value: "sun"},
+		{Name: "foo", Value: "baz", Sensitive: true},
+		{Name: "date", Value: "mon"},
+		{Name: "lorem", Value: "ipsum"},
+	}
+	require.Equal(t, expected, decodeAll(t, dec.DecodeStream(0, data)))
+	var hfs []HeaderField
+	require.NoError(t, dec.DecodeStreamBytes(4, data, func(name, value []byte, flags FieldFlags) error {
+		hfs = append(hfs, HeaderField{Name: string(name), Value: string(value), Sensitive: flags&FieldSensitive > 0})
+		return nil
+	}))
+	require.Equal(t, expected, hfs)
+	// without a stream ID, the header block can't be acknowledged
+	err := dec.DecodeBytes(data, func(_, _ []byte, _ FieldFlags) error { return nil })
+	require.ErrorIs(t, err, ErrStreamRequired)
+}
+
+func TestDecoderDecodeBytesErrors(t *testing.T) {
+	t.Run("callback error", func(t *testing.T) {
+		testErr := errors.New("test error")
+		var count int
+		err := NewDecoder().DecodeBytes(indexedField.Data, func(_, _ []byte, _ FieldFlags) error {
+			count++
+			return testErr
+		})
+		require.ErrorIs(t, err, testErr)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("invalid field line", func(t *testing.T) {
+		data := wire.AppendVarInt(nil, 6, 10000)
+		data[0] ^= 0x80 | 0x40
+		err := NewDecoder().DecodeBytes(insertPrefix(data), func(_, _ []byte, _ FieldFlags) error { return nil })
+		var qerr *Error
+		require.ErrorAs(t, err, &qerr)
+		require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+		require.Equal(t, &DecodingError{Offset: 2, Type: wire.IndexedFieldLine, Err: invalidIndexError(10000)}, qerr.Err)
+	})
+
+	t.Run("invalid Huffman encoding", func(t *testing.T) {
+		data := wire.AppendVarInt(nil, 4, 49)
+		data[0] ^= 0x40 | 0x10
+		data = append(data, 0x80|1, 0xff) // Huffman-encoded, invalid padding
+		err := NewDecoder().DecodeBytes(insertPrefix(data), func(_, _ []byte, _ FieldFlags) error { return nil })
+		require.ErrorIs(t, err, hpack.ErrInvalidHuffman)
+	})
+
+	t.Run("field section too large", func(t *testing.T) {
+		for _, data := range [][]byte{literalFieldWithNameReference.Data, literalFieldWithHuffmanEncoding.Data} {
+			err := NewDecoder(WithMaxFieldSectionSize(100)).DecodeBytes(data, func(_, _ []byte, _ FieldFlags) error { return nil })
+			require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+		}
+	})
+}
+
+func TestDecoderRequiredInsertCount(t *testing.T) {
+	tests := []struct {
+		name               string
+		encoded            uint64
+		maxTableCapacity   uint64
+		totalInserts       uint64
+		expected           uint64
+		expectedErrMessage string
+	}{
+		{name: "zero", encoded: 0, maxTableCapacity: 0, expected: 0},
+		{name: "no wrapping", encoded: 4, maxTableCapacity: 100, totalInserts: 3, expected: 3},
+		// maxEntries = 3, fullRange = 6
+		{name: "wrapped", encoded: 2, maxTableCapacity: 100, totalInserts: 6, expected: 7},
+		{name: "wrapped, ahead of inserts", encoded: 1, maxTableCapacity: 100, totalInserts: 10, expected: 12},
+		{name: "larger than full range", encoded: 7, maxTableCapacity: 100, expectedErrMessage: "invalid Required Insert Count"},
+		{name: "non-zero without dynamic table", encoded: 1, maxTableCapacity: 0, expectedErrMessage: "invalid Required Insert Count"},
+		{name: "unwrapped", encoded: 6, maxTableCapacity: 100, totalInserts: 7, expected: 5},
+		{name: "too far ahead of inserts", encoded: 6, maxTableCapacity: 100, totalInserts: 1, expectedErrMessage: "invalid Required Insert Count"},
+		{name: "would be zero", encoded: 1, maxTableCapacity: 100, totalInserts: 0, expectedErrMessage: "invalid Required Insert Count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ric, err := decodeRequiredInsertCount(tt.encoded, tt.maxTableCapacity, tt.totalInserts)
+			if tt.expectedErrMessage != "" {
+				require.EqualError(t, err, tt.expectedErrMessage)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, ric)
+		})
+	}
+}
+
+func TestDecoderEncoderStreamInstructions(t *testing.T) {
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 300)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)         // absolute index 0
+	encoderStream = wire.AppendInsertWithNameReference(encoderStream, true, 0, "quic-go.net", false)    // absolute index 1
+	encoderStream = wire.AppendInsertWithNameReference(encoderStream, false, 0, "example.com", false)   // absolute index 2
+	encoderStream = wire.AppendDuplicate(encoderStream, 2)                                              // absolute index 3
+	encoderStream = wire.AppendInsertWithNameReference(encoderStream, true, 31, "gzip, deflate", false) // absolute index 4
+
+	expected := []HeaderField{
+		{Name: "foo", Value: "bar"},
+		{Name: ":authority", Value: "quic-go.net"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: "foo", Value: "bar"},
+		{Name: "accept-encoding", Value: "gzip, deflate"},
+	}
+
+	t.Run("in one piece", func(t *testing.T) {
+		dec := NewDecoder(WithMaxTableCapacity(300))
+		require.NoError(t, dec.HandleEncoderStream(encoderStream))
+		require.Equal(t, expected, dec.table.entries)
+		require.Equal(t, uint64(300), dec.table.capacity)
+		require.Empty(t, dec.encoderStreamBuf)
+	})
+
+	t.Run("byte by byte", func(t *testing.T) {
+		dec := NewDecoder(WithMaxTableCapacity(300))
+		for _, b := range encoderStream {
+			require.NoError(t, dec.HandleEncoderStream([]byte{b}))
+		}
+		require.Equal(t, expected, dec.table.entries)
+		require.Empty(t, dec.encoderStreamBuf)
+	})
+}
+
+func TestDecoderEncoderStreamErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{
+			name:     "capacity exceeding the maximum",
+			input:    wire.AppendSetDynamicTableCapacity(nil, 101),
+			expected: "dynamic table capacity exceeds the maximum table capacity",
+		},
+		{
+			name:     "insert without capacity",
+			input:    wire.AppendInsertWithLiteralName(nil, "foo", false, "bar", false),
+			expected: "dynamic table entry exceeds the table capacity",
+		},
+		{
+			name:     "entry larger than the capacity",
+			input:    wire.AppendInsertWithLiteralName(wire.AppendSetDynamicTableCapacity(nil, 40), "foo", false, "barbaz", false),
+			expected: "dynamic table entry exceeds the table capacity",
+		},
+		{
+			name:     "invalid static name reference",
+			input:    wire.AppendInsertWithNameReference(wire.AppendSetDynamicTableCapacity(nil, 100), true, 99, "foo", false),
+			expected: "invalid indexed representation index 99",
+		},
+		{
+			name:     "invalid dynamic name reference",
+			input:    wire.AppendInsertWithNameReference(wire.AppendSetDynamicTableCapacity(nil, 100), false, 0, "foo", false),
+			expected: "invalid dynamic table index",
+		},
+		{
+			name:     "invalid duplicate",
+			input:    wire.AppendDuplicate(wire.AppendInsertWithLiteralName(wire.AppendSetDynamicTableCapacity(nil, 100), "foo", false, "bar", false), 1),
+			expected: "invalid dynamic table index",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoder(WithMaxTableCapacity(100))
+			err := dec.HandleEncoderStream(tt.input)
+			var qerr *Error
+			require.ErrorAs(t, err, &qerr)
+			require.Equal(t, ErrCodeEncoderStreamError, qerr.Code)
+			require.EqualError(t, qerr.Err, tt.expected)
+		})
+	}
+}
+
+func TestDecoderEncoderStreamInstructionTooLarge(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(100))
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendSetDynamicTableCapacity(nil, 100)))
+	// the announced length of the value makes this instruction too large to ever be valid
+	b := wire.AppendVarInt(nil, 5, 3)
+	b[0] |= 0x40
+	b = append(b, "foo"...)
+	b = wire.AppendVarInt(b, 7, 1000)
+	require.NoError(t, dec.HandleEncoderStream(b))
+	require.ErrorIs(t, dec.HandleEncoderStream(make([]byte, 500)), errInstructionTooLarge)
+}
+
+func TestDecoderDynamicTableReferences(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(200), WithMaxBlockedStreams(1))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)      // absolute index 0
+	encoderStream = wire.AppendInsertWithNameReference(encoderStream, true, 0, "quic-go.net", false) // absolute index 1
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "lorem", false, "ipsum", false)  // absolute index 2
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 3
+	t.Run("relative indices", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 4}) // Base = 3
+		data = append(data, 0x80|2)                                                                // indexed field line, absolute index 0
+		data = append(data, 0x40|1, 0x03)                                                          // literal with name reference, absolute index 1
+		data = append(data, "foo"...)
+		data = append(data, 0x80|0) // indexed field line, absolute index 2
+		require.Equal(t,
+			[]HeaderField{{Name: "foo", Value: "bar"}, {Name: ":authority", Value: "foo"}, {Name: "lorem", Value: "ipsum"}},
+			decodeAll(t, dec.DecodeStream(0, data)),
+		)
+	})
+
+	t.Run("post-base indices", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 4, Sign: true, DeltaBase: 1}) // Base = 1
+		data = append(data, 0x80|0)                                                                                          // indexed field line, absolute index 0
+		data = append(data, 0x10|0)                                                                                          // indexed field line with post-base index, absolute index 1
+		data = append(data, 0x00|1, 0x03)                                                                                    // literal with post-base name reference, absolute index 2
+		data = append(data, "foo"...)
+		require.Equal(t,
+			[]HeaderField{{Name: "foo", Value: "bar"}, {Name: ":authority", Value: "quic-go.net"}, {Name: "lorem", Value: "foo"}},
+			decodeAll(t, dec.DecodeStream(4, data)),
+		)
+	})
+
+	t.Run("reference beyond the Required Insert Count", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 3, Sign: true}) // Required Insert Count = 2, Base = 1
+		data = append(data, 0x10|1)                                                                            // indexed field line with post-base index, absolute index 2
+		_, err := dec.DecodeStream(8, data)()
+		require.ErrorIs(t, err, errInvalidDynamicIndex)
+	})
+
+	t.Run("relative index beyond the Base", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 4}) // Base = 3
+		data = append(data, 0x80|3)
+		_, err := dec.DecodeStream(12, data)()
+		require.ErrorIs(t, err, errInvalidDynamicIndex)
+	})
+
+	t.Run("missing inserts", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 5}) // Required Insert Count = 4
+		data = append(data, 0x80|0)
+		_, err := dec.DecodeStream(16, data)()
+		require.ErrorIs(t, err, ErrBlocked)
+		require.NoError(t, dec.CancelStream(16))
+	})
+}
+
+func TestDecoderDynamicTableEviction(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(100))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 100)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false) // absolute index 0, size 38
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "bar", false, "baz", false) // absolute index 1, size 38
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "baz", false, "foo", false) // absolute index 2, evicts entry 0
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+	require.Equal(t, uint64(3), dec.table.insertCount())
+
+	// maxEntries = 3, fullRange = 6, Required Insert Count = 3
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 4}) // Base = 3
+	data = append(data, 0x80|1)                                                                // absolute index 1
+	require.Equal(t, []HeaderField{{Name: "bar", Value: "baz"}}, decodeAll(t, dec.DecodeStream(0, data)))
+
+	data = wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 4}) // Base = 3
+	data = append(data, 0x80|2)                                                               // absolute index 0, which was evicted
+	_, err := dec.DecodeStream(4, data)()
+	require.ErrorIs(t, err, errInvalidDynamicIndex)
+}
+
+func TestDecoderInsertCountIncrement(t *testing.T) {
+	var decoderStream bytes.Buffer
+	dec := NewDecoder(WithMaxTableCapacity(200), WithDecoderStream(&decoderStream))
+
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+	require.Empty(t, decoderStream.Bytes()) // no insertions, no Insert Count Increment
+
+	encoderStream = wire.AppendInsertWithLiteralName(nil, "foo", false, "bar", false)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "lorem", false, "ipsum", false)
+	require.NoError(t, dec.HandleEncoderStream(encoderStream[:len(encoderStream)-1]))
+	require.Equal(t, []byte{0x01}, decoderStream.Bytes())
+	decoderStream.Reset()
+	require.NoError(t, dec.HandleEncoderStream(encoderStream[len(encoderStream)-1:]))
+	require.Equal(t, []byte{0x01}, decoderStream.Bytes())
+}
+
+func TestDecoderSectionAcknowledgment(t *testing.T) {
+	var decoderStream bytes.Buffer
+	dec := NewDecoder(WithMaxTableCapacity(200), WithDecoderStream(&decoderStream))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+	decoderStream.Reset()
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 1
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 2}) // Base = 1
+	data = append(data, 0x80|0)                                                                // absolute index 0
+
+	t.Run("without a stream ID", func(t *testing.T) {
+		_, err := dec.Decode(data)()
+		require.ErrorIs(t, err, ErrStreamRequired)
+		var qerr *Error
+		require.False(t, errors.As(err, &qerr)) // not a QPACK error
+		require.Empty(t, decoderStream.Bytes())
+	})
+
+	t.Run("with acknowledgment", func(t *testing.T) {
+		decode := dec.DecodeStream(200, data)
+		require.Equal(t, []HeaderField{{Name: "foo", Value: "bar"}}, decodeAll(t, decode))
+		require.Equal(t, wire.AppendSectionAcknowledgment(nil, 200), decoderStream.Bytes())
+		// calling the DecodeFunc again doesn't acknowledge the section again
+		_, err := decode()
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, wire.AppendSectionAcknowledgment(nil, 200), decoderStream.Bytes())
+		decoderStream.Reset()
+	})
+
+	t.Run("field sections not referencing the dynamic table", func(t *testing.T) {
+		require.Equal(t, indexedField.Expected, decodeAll(t, dec.DecodeStream(4, indexedField.Data)))
+		require.Empty(t, decoderStream.Bytes())
+	})
+
+	// the encoder now knows that the decoder received the first insertion
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendInsertWithLiteralName(nil, "lorem", false, "ipsum", false)))
+	require.Equal(t, []byte{0x01}, decoderStream.Bytes())
+}
+
+func TestDecoderCancelStream(t *testing.T) {
+	t.Run("with dynamic table", func(t *testing.T) {
+		var decoderStream bytes.Buffer
+		dec := NewDecoder(WithMaxTableCapacity(200), WithDecoderStream(&decoderStream))
+		require.NoError(t, dec.CancelStream(1337))
+		require.Equal(t, wire.AppendStreamCancellation(nil, 1337), decoderStream.Bytes())
+	})
+
+	t.Run("without dynamic table", func(t *testing.T) {
+		var decoderStream bytes.Buffer
+		dec := NewDecoder(WithDecoderStream(&decoderStream))
+		require.NoError(t, dec.CancelStream(1337))
+		require.Empty(t, decoderStream.Bytes())
+	})
+}
+
+func TestDecoderFailsWhenDecoderStreamErrs(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(200), WithDecoderStream(&errWriter{fail: true}))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)
+	require.ErrorIs(t, dec.HandleEncoderStream(encoderStream), io.ErrClosedPipe)
+	require.ErrorIs(t, dec.CancelStream(4), io.ErrClosedPipe)
+}
+
+func TestDecoderDecodeWhileWritingDecoderStream(t *testing.T) {
+	decoderStream := newBlockingWriter()
+	dec := NewDecoder(WithMaxTableCapacity(200), WithDecoderStream(decoderStream))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)
+	errChan := make(chan error, 1)
+	go func() { errChan <- dec.HandleEncoderStream(encoderStream) }()
+
+	select {
+	case <-decoderStream.writing:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	// the mutex isn't held while the Insert Count Increment is written to the decoder stream
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 1
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 2}) // Base = 1
+	data = append(data, 0x80|0)                                                                // absolute index 0
+	decode := dec.DecodeStream(4, data)
+	decodeErrChan := make(chan error, 1)
+	var hf HeaderField
+	go func() {
+		var err error
+		hf, err = decode()
+		decodeErrChan <- err
+	}()
+	select {
+	case err := <-decodeErrChan:
+		require.NoError(t, err)
+		require.Equal(t, HeaderField{Name: "foo", Value: "bar"}, hf)
+	case <-time.After(time.Second):
+		t.Fatal("decoding blocked by the decoder stream")
+	}
+
+	close(decoderStream.unblock)
+	select {
+	case err := <-errChan:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	_, err := decode()
+	require.ErrorIs(t, err, io.EOF)
+	// the Section Acknowledgment is written after the Insert Count Increment
+	require.Equal(t,
+		wire.AppendSectionAcknowledgment(wire.AppendInsertCountIncrement(nil, 1), 4),
+		decoderStream.Bytes(),
+	)
+}
+
+func TestDecoderStreamInstructions(t *testing.T) {
+	b := wire.AppendSectionAcknowledgment(nil, 4)
+	require.Equal(t, []byte{0x84}, b)
+	b = wire.AppendStreamCancellation(nil, 8)
+	require.Equal(t, []byte{0x48}, b)
+	b = wire.AppendInsertCountIncrement(nil, 3)
+	require.Equal(t, []byte{0x03}, b)
+	// prefix integers spanning multiple bytes
+	b = wire.AppendSectionAcknowledgment(nil, 1000)
+	streamID, rest, err := wire.ReadVarInt(7, b)
+	require.NoError(t, err)
+	require.Empty(t, rest)
+	require.Equal(t, uint64(1000), streamID)
+	require.Equal(t, byte(0x80), b[0]&0x80)
+}
+
+func TestDecoderBlockedStreams(t *testing.T) {
+	var decoderStream bytes.Buffer
+	dec := NewDecoder(
+		WithMaxTableCapacity(200),
+		WithMaxBlockedStreams(1),
+		WithDecoderStream(&decoderStream),
+	)
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendSetDynamicTableCapacity(nil, 200)))
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 2
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 3}) // Base = 2
+	data = append(data, 0xc0|17)                                                               // static table: :method GET
+	data = append(data, 0x80|0)                                                                // absolute index 1
+	data = append(data, 0x80|1)                                                                // absolute index 0
+
+	decode := dec.DecodeStream(4, data)
+	_, err := decode()
+	require.ErrorIs(t, err, ErrBlocked)
+	unblocked := dec.Unblocked(4)
+	select {
+	case <-unblocked:
+		t.Fatal("stream should be blocked")
+	default:
+	}
+	// calling the DecodeFunc again doesn't count as another blocked stream
+	_, err = decode()
+	require.ErrorIs(t, err, ErrBlocked)
+
+	// only a single stream can be blocked
+	_, err = dec.DecodeStream(8, data)()
+	var qerr *Error
+	require.ErrorAs(t, err, &qerr)
+	require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+	require.ErrorIs(t, err, errTooManyBlockedStreams)
+
+	// the first insertion doesn't unblock the stream
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendInsertWithLiteralName(nil, "foo", false, "bar", false)))
+	_, err = decode()
+	require.ErrorIs(t, err, ErrBlocked)
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendInsertWithLiteralName(nil, "lorem", false, "ipsum", false)))
+	select {
+	case <-unblocked:
+	default:
+		t.Fatal("stream should have been unblocked")
+	}
+	decoderStream.Reset()
+	require.Equal(t,
+		[]HeaderField{{Name: ":method", Value: "GET"}, {Name: "lorem", Value: "ipsum"}, {Name: "foo", Value: "bar"}},
+		decodeAll(t, decode),
+	)
+	require.Equal(t, wire.AppendSectionAcknowledgment(nil, 4), decoderStream.Bytes())
+	require.Empty(t, dec.blockedStreams)
+}
+
+func TestDecoderBlockedStreamsDisabled(t *testing.T) {
+	dec := NewDecoder(WithMaxTableCapacity(200))
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 3}) // Required Insert Count = 2, Base = 2
+	data = append(data, 0x80|0)
+	_, err := dec.DecodeStream(4, data)()
+	var qerr *Error
+	require.ErrorAs(t, err, &qerr)
+	require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+}
+
+func TestDecoderBlockedStreamCancellation(t *testing.T) {
+	var decoderStream bytes.Buffer
+	dec := NewDecoder(
+		WithMaxTableCapacity(200),
+		WithMaxBlockedStreams(1),
+		WithDecoderStream(&decoderStream),
+	)
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 3}) // Required Insert Count = 2, Base = 2
+	data = append(data, 0x80|0)
+	_, err := dec.DecodeStream(4, data)()
+	require.ErrorIs(t, err, ErrBlocked)
+	unblocked := dec.Unblocked(4)
+
+	require.NoError(t, dec.CancelStream(4))
+	require.Equal(t, wire.AppendStreamCancellation(nil, 4), decoderStream.Bytes())
+	select {
+	case <-unblocked:
+	default:
+		t.Fatal("channel should have been closed")
+	}
+	// another stream can now be blocked
+	_, err = dec.DecodeStream(8, data)()
+	require.ErrorIs(t, err, ErrBlocked)
+}
+
+func TestDecoderUnblockedForStreamsThatAreNotBlocked(t *testing.T) {
+	dec := NewDecoder()
+	select {
+	case <-dec.Unblocked(4):
+	default:
+		t.Fatal("channel should be closed")
+	}
+}
+
+func TestDecoderDecodeContext(t *testing.T) {
+	newDecoder := func(decoderStream io.Writer) *Decoder {
+		dec := NewDecoder(
+			WithMaxTableCapacity(200),
+			WithMaxBlockedStreams(10),
+			WithDecoderStream(decoderStream),
+		)
+		require.NoError(t, dec.HandleEncoderStream(wire.AppendSetDynamicTableCapacity(nil, 200)))
+		return dec
+	}
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 1
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 2}) // Base = 1
+	data = append(data, 0x80|0)                                                                // absolute index 0
+
+	t.Run("not blocked", func(t *testing.T) {
+		var decoderStream bytes.Buffer
+		dec := newDecoder(&decoderStream)
+		require.NoError(t, dec.HandleEncoderStream(wire.AppendInsertWithLiteralName(nil, "foo", false, "bar", false)))
+		decoderStream.Reset()
+		require.Equal(t,
+			[]HeaderField{{Name: "foo", Value: "bar"}},
+			decodeAll(t, dec.DecodeContext(context.Background(), 4, data)),
+		)
+		require.Equal(t, wire.AppendSectionAcknowledgment(nil, 4), decoderStream.Bytes())
+	})
+
+	t.Run("waiting for the encoder stream", func(t *testing.T) {
+		dec := newDecoder(&bytes.Buffer{})
+		done := make(chan struct{})
+		var hf HeaderField
+		var err error
+		go func() {
+			defer close(done)
+			hf, err = dec.DecodeContext(context.Background(), 4, data)()
+		}()
+
+		// wait until the stream is blocked
+		require.Eventually(t, func() bool {
+			dec.mutex.Lock()
+			defer dec.mutex.Unlock()
+			return len(dec.blockedStreams) == 1
+		}, time.Second, time.Millisecond)
+		select {
+		case <-done:
+			t.Fatal("decoding should be blocked")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		require.NoError(t, dec.HandleEncoderStream(wire.AppendInsertWithLiteralName(nil, "foo", false, "bar", false)))
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		require.NoError(t, err)
+		require.Equal(t, HeaderField{Name: "foo", Value: "bar"}, hf)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		var decoderStream bytes.Buffer
+		dec := newDecoder(&decoderStream)
+		ctx, cancel := context.WithCancel(context.Background())
+		errChan := make(chan error, 1)
+		decode := dec.DecodeContext(ctx, 4, data)
+		go func() {
+			_, err := decode()
+			errChan <- err
+		}()
+
+		require.Eventually(t, func() bool {
+			dec.mutex.Lock()
+			defer dec.mutex.Unlock()
+			return len(dec.blockedStreams) == 1
+		}, time.Second, time.Millisecond)
+		cancel()
+		select {
+		case err := <-errChan:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		dec.mutex.Lock()
+		require.Equal(t, wire.AppendStreamCancellation(nil, 4), decoderStream.Bytes())
+		require.Empty(t, dec.blockedStreams)
+		dec.mutex.Unlock()
+
+		// the DecodeFunc keeps returning the error
+		_, err := decode()
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("stream canceled", func(t *testing.T) {
+		var decoderStream bytes.Buffer
+		dec := newDecoder(&decoderStream)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		errChan := make(chan error, 1)
+		decode := dec.DecodeContext(ctx, 4, data)
+		go func() {
+			_, err := decode()
+			errChan <- err
+		}()
+
+		require.Eventually(t, func() bool {
+			dec.mutex.Lock()
+			defer dec.mutex.Unlock()
+			return len(dec.blockedStreams) == 1
+		}, time.Second, time.Millisecond)
+		require.NoError(t, dec.CancelStream(4))
+		select {
+		case err := <-errChan:
+			require.ErrorIs(t, err, ErrStreamCanceled)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		// the stream is not blocked again, and only a single Stream Cancellation is sent
+		dec.mutex.Lock()
+		require.Empty(t, dec.blockedStreams)
+		require.Equal(t, wire.AppendStreamCancellation(nil, 4), decoderStream.Bytes())
+		dec.mutex.Unlock()
+		_, err := decode()
+		require.ErrorIs(t, err, ErrStreamCanceled)
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		dec := newDecoder(&bytes.Buffer{})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := dec.DecodeContext(ctx, 4, data)()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
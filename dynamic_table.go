@@ -0,0 +1,73 @@
+package qpack
+
+import "errors"
+
+// entryOverhead is the overhead added to the length of the name and value
+// when calculating the size of a dynamic table entry, see Section 3.2.1 of RFC 9204.
+const entryOverhead = 32
+
+var errEntryTooLarge = errors.New("dynamic table entry exceeds the table capacity")
+
+func entrySize(name, value string) uint64 {
+	return uint64(len(name)+len(value)) + entryOverhead
+}
+
+// The dynamicTable is the QPACK dynamic table, see Section 3.2 of RFC 9204.
+// Entries are addressed by their absolute index.
+// The first entry inserted has absolute index 0, and the index is incremented
+// by one for every insertion. Entries are evicted in the order they were inserted.
+type dynamicTable struct {
+	entries []HeaderField
+	evicted uint64 // number of evicted entries, which is also the absolute index of entries[0]
+
+	size     uint64 // sum of the size of all entries
+	capacity uint64
+}
+
+// insertCount is the total number of insertions into the dynamic table.
+func (t *dynamicTable) insertCount() uint64 {
+	return t.evicted + uint64(len(t.entries))
+}
+
+// get returns the entry at the given absolute index.
+// It reports false if the entry was never inserted or has already been evicted.
+func (t *dynamicTable) get(absIndex uint64) (HeaderField, bool) {
+	if absIndex < t.evicted || absIndex >= t.insertCount() {
+		return HeaderField{}, false
+	}
+	return t.entries[absIndex-t.evicted], true
+}
+
+// setCapacity sets the capacity of the table, evicting entries as needed.
+func (t *dynamicTable) setCapacity(capacity uint64) {
+	t.capacity = capacity
+	t.evictUntil(capacity)
+}
+
+// insert adds a new entry, evicting the oldest entries to make room for it.
+// It is an error to insert an entry larger than the table capacity.
+func (t *dynamicTable) insert(hf HeaderField) error {
+	size := entrySize(hf.Name, hf.Value)
+	if size > t.capacity {
+		return errEntryTooLarge
+	}
+	t.evictUntil(t.capacity - size)
+	t.entries = append(t.entries, hf)
+	t.size += size
+	return nil
+}
+
+// evictUntil evicts the oldest entries until the table size is at most size.
+func (t *dynamicTable) evictUntil(size uint64) {
+	for t.size > size {
+		t.evictOldest()
+	}
+}
+
+func (t *dynamicTable) evictOldest() {
+	hf := t.entries[0]
+	t.entries[0] = HeaderField{} // allow the strings to be garbage collected
+	t.entries = t.entries[1:]
+	t.evicted++
+	t.size -= entrySize(hf.Name, hf.Value)
+}
@@ -0,0 +1,62 @@
+package qpack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicTableInsertAndEvict(t *testing.T) {
+	var table dynamicTable
+	table.setCapacity(100)
+	require.NoError(t, table.insert(HeaderField{Name: "foo", Value: "bar"}))  // size 38
+	require.NoError(t, table.insert(HeaderField{Name: "lorem", Value: "ip"})) // size 39
+	require.Equal(t, uint64(77), table.size)
+	require.Equal(t, uint64(2), table.insertCount())
+
+	hf, ok := table.get(0)
+	require.True(t, ok)
+	require.Equal(t, HeaderField{Name: "foo", Value: "bar"}, hf)
+	_, ok = table.get(2)
+	require.False(t, ok)
+
+	// evicts the first entry
+	require.NoError(t, table.insert(HeaderField{Name: "dolor", Value: "sit"}))
+	require.Equal(t, uint64(3), table.insertCount())
+	require.Equal(t, uint64(79), table.size)
+	_, ok = table.get(0)
+	require.False(t, ok)
+	hf, ok = table.get(1)
+	require.True(t, ok)
+	require.Equal(t, HeaderField{Name: "lorem", Value: "ip"}, hf)
+	hf, ok = table.get(2)
+	require.True(t, ok)
+	require.Equal(t, HeaderField{Name: "dolor", Value: "sit"}, hf)
+}
+
+func TestDynamicTableReducingCapacity(t *testing.T) {
+	var table dynamicTable
+	table.setCapacity(100)
+	require.NoError(t, table.insert(HeaderField{Name: "foo", Value: "bar"}))
+	require.NoError(t, table.insert(HeaderField{Name: "bar", Value: "baz"}))
+	table.setCapacity(40)
+	require.Equal(t, uint64(38), table.size)
+	_, ok := table.get(0)
+	require.False(t, ok)
+	_, ok = table.get(1)
+	require.True(t, ok)
+
+	table.setCapacity(0)
+	require.Zero(t, table.size)
+	require.Equal(t, uint64(2), table.insertCount())
+}
+
+func TestDynamicTableEntryTooLarge(t *testing.T) {
+	var table dynamicTable
+	table.setCapacity(40)
+	require.NoError(t, table.insert(HeaderField{Name: "foo", Value: "bar"}))
+	require.ErrorIs(t, table.insert(HeaderField{Name: "foo", Value: "barbaz"}), errEntryTooLarge)
+	// the table is unchanged
+	require.Equal(t, uint64(1), table.insertCount())
+	require.Equal(t, uint64(38), table.size)
+}
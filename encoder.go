@@ -1,95 +1,475 @@
 package qpack
 
 import (
+	"fmt"
 	"io"
+	"sync"
 
+	"github.com/quic-go/qpack/wire"
 	"golang.org/x/net/http2/hpack"
 )
 
 // An Encoder performs QPACK encoding.
+//
+// When the dynamic table is used, the peer's decoder stream needs to be passed to HandleDecoderStream.
+// It is safe to call HandleDecoderStream concurrently with encoding header blocks.
 type Encoder struct {
 	wrotePrefix bool
 
 	w   io.Writer
 	buf []byte
+
+	// The following fields are only used if the dynamic table is enabled, see WithEncoderStream.
+	encoderStream    io.Writer
+	maxTableCapacity uint64 // the peer's SETTINGS_QPACK_MAX_TABLE_CAPACITY
+	instructionBuf   []byte
+	prefixBuf        []byte
+	streamID         uint64
+	section          encoderSection
+
+	isSensitive func(HeaderField) bool
+	huffman     HuffmanPolicy
+
+	maxFieldSectionSize uint64 // the peer's SETTINGS_MAX_FIELD_SECTION_SIZE, or noLimit
+	sectionSize         uint64 // the size of the field section that is currently encoded
+
+	mutex sync.Mutex // protects the table and the decoder stream buffer
+	table *encoderTable
+	// data received on the decoder stream that doesn't form a complete instruction yet
+	decoderStreamBuf []byte
+}
+
+// encoderSection is the state of the field section that is currently encoded
+// when the dynamic table is used.
+type encoderSection struct {
+	started             bool
+	base                uint64 // only entries with an absolute index smaller than the Base are referenced
+	requiredInsertCount uint64
+	minRef              uint64 // smallest absolute index referenced
+}
+
+// An EncoderOption configures an Encoder.
+type EncoderOption func(*Encoder)
+
+// WithEncoderStream enables the use of the dynamic table.
+// Encoder stream instructions are written to w.
+// maxTableCapacity is the value of the peer's SETTINGS_QPACK_MAX_TABLE_CAPACITY setting.
+//
+// The Encoder only references dynamic table entries after the decoder has acknowledged them,
+// so the encoded field sections never block the decoder.
+func WithEncoderStream(w io.Writer, maxTableCapacity uint64) EncoderOption {
+	return func(e *Encoder) {
+		e.encoderStream = w
+		e.maxTableCapacity = maxTableCapacity
+	}
+}
+
+// WithSensitivityPolicy sets a function that decides if a header field is sensitive,
+// i.e. if it should never be indexed.
+// It is called for all header fields that don't have the Sensitive flag set.
+// DefaultSensitivityPolicy marks header fields carrying credentials as sensitive.
+func WithSensitivityPolicy(isSensitive func(HeaderField) bool) EncoderOption {
+	return func(e *Encoder) { e.isSensitive = isSensitive }
+}
+
+// A HuffmanPolicy determines if string literals are Huffman-encoded.
+type HuffmanPolicy uint8
+
+const (
+	// HuffmanIfShorter uses Huffman encoding for a string literal
+	// only if it is shorter than the raw string. This is the default.
+	HuffmanIfShorter HuffmanPolicy = iota
+	// HuffmanAlways always uses Huffman encoding.
+	HuffmanAlways
+	// HuffmanNever never uses Huffman encoding.
+	HuffmanNever
+)
+
+// WithHuffmanPolicy sets the policy for Huffman-encoding string literals,
+// both in field sections and in encoder stream instructions.
+// Forcing raw or Huffman-encoded string literals is mostly useful for deterministic test output.
+func WithHuffmanPolicy(policy HuffmanPolicy) EncoderOption {
+	return func(e *Encoder) { e.huffman = policy }
+}
+
+// WithPeerMaxFieldSectionSize sets the maximum size of a field section
+// that the peer is willing to accept, i.e. the value of the peer's SETTINGS_MAX_FIELD_SECTION_SIZE setting.
+// The size is calculated as described in Section 4.2.2 of RFC 9114.
+// WriteField returns a *FieldSectionTooLargeError instead of writing a header field that would exceed this size.
+// By default, the size of a field section is not limited.
+func WithPeerMaxFieldSectionSize(size uint64) EncoderOption {
+	return func(e *Encoder) { e.maxFieldSectionSize = size }
+}
+
+// A FieldSectionTooLargeError is returned by WriteField when writing a header field
+// would exceed the peer's maximum field section size, see WithPeerMaxFieldSectionSize.
+// The header field is not written. The field section can still be completed using Close,
+// but it lacks the header field.
+type FieldSectionTooLargeError struct {
+	Size  uint64 // the size of the field section, including the header field that wasn't written
+	Limit uint64 // the peer's maximum field section size
 }
 
+func (e *FieldSectionTooLargeError) Error() string {
+	return fmt.Sprintf("field section size of %d bytes exceeds the peer's limit of %d bytes", e.Size, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrFieldSectionTooLarge) return true.
+func (e *FieldSectionTooLargeError) Is(target error) bool { return target == ErrFieldSectionTooLarge }
+
 // NewEncoder returns a new Encoder which performs QPACK encoding. An
 // encoded data is written to w.
-func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{w: w, maxFieldSectionSize: noLimit}
+	for _, opt := range opts {
+		opt(e)
+	}
+	// The Required Insert Count can only be encoded if the table can hold at least one entry.
+	if e.encoderStream != nil && e.maxTableCapacity >= entryOverhead {
+		e.table = newEncoderTable()
+	}
+	return e
+}
+
+// SetStreamID sets the ID of the stream that the following field sections are sent on.
+// This is only needed when the dynamic table is used,
+// since the decoder acknowledges field sections by their stream ID.
+func (e *Encoder) SetStreamID(streamID uint64) {
+	e.streamID = streamID
 }
 
 // WriteField encodes f into a single Write to e's underlying Writer.
 // This function may also produce bytes for the Header Block Prefix
 // if necessary. If produced, it is done before encoding f.
+//
+// When the dynamic table is used, the Header Block Prefix depends on all header fields
+// in the header block. The encoded header block is then written in a single Write
+// when Close is called.
 func (e *Encoder) WriteField(f HeaderField) error {
+	if e.table != nil {
+		var err error
+		e.buf, err = e.appendField(e.buf, f)
+		return err
+	}
+
+	b := e.buf[:0]
 	// write the Header Block Prefix
 	if !e.wrotePrefix {
-		e.buf = appendVarInt(e.buf, 8, 0)
-		e.buf = appendVarInt(e.buf, 7, 0)
-		e.wrotePrefix = true
+		b = e.appendFieldSectionPrefix(b, encoderSection{})
 	}
+	b, err := e.appendField(b, f)
+	if err != nil {
+		return err
+	}
+	e.wrotePrefix = true
+	_, err = e.w.Write(b)
+	e.buf = b[:0]
+	return err
+}
 
-	idxAndVals, nameFound := encoderMap[f.Name]
-	if nameFound {
-		if idxAndVals.values == nil {
-			if len(f.Value) == 0 {
-				e.writeIndexedField(idxAndVals.idx)
-			} else {
-				e.writeLiteralFieldWithNameReference(&f, idxAndVals.idx)
-			}
-		} else {
-			valIdx, valueFound := idxAndVals.values[f.Value]
-			if valueFound {
-				e.writeIndexedField(valIdx)
-			} else {
-				e.writeLiteralFieldWithNameReference(&f, idxAndVals.idx)
+// AppendFieldSection encodes a complete field section, including the Header Block Prefix,
+// and appends it to dst. Nothing is written to e's underlying Writer.
+// If dst has enough capacity, no memory is allocated.
+// If an error occurs, dst is returned unmodified, and the field section must not be sent.
+//
+// AppendFieldSection must not be called while a field section is encoded using WriteField,
+// i.e. before that field section is completed by calling Close.
+func (e *Encoder) AppendFieldSection(dst []byte, fields []HeaderField) ([]byte, error) {
+	defer func() { e.sectionSize = 0 }()
+
+	if e.table == nil {
+		b := e.appendFieldSectionPrefix(dst, encoderSection{})
+		for _, f := range fields {
+			var err error
+			if b, err = e.appendField(b, f); err != nil {
+				return dst, err
 			}
 		}
-	} else {
-		e.writeLiteralFieldWithoutNameReference(f)
+		return b, nil
 	}
 
-	_, err := e.w.Write(e.buf)
-	e.buf = e.buf[:0]
-	return err
+	for _, f := range fields {
+		var err error
+		if e.buf, err = e.appendField(e.buf, f); err != nil {
+			e.section = encoderSection{}
+			e.buf = e.buf[:0]
+			return dst, err
+		}
+	}
+	return e.appendSection(dst), nil
+}
+
+// appendField encodes a single field line and appends it to b.
+func (e *Encoder) appendField(b []byte, f HeaderField) ([]byte, error) {
+	if !f.Sensitive && e.isSensitive != nil {
+		f.Sensitive = e.isSensitive(f)
+	}
+	size := e.sectionSize + entrySize(f.Name, f.Value)
+	if size > e.maxFieldSectionSize {
+		return b, &FieldSectionTooLargeError{Size: size, Limit: e.maxFieldSectionSize}
+	}
+	e.sectionSize = size
+
+	if e.table != nil {
+		return e.appendFieldDynamic(b, f)
+	}
+	idx, matchesValue, nameFound := lookupStatic(f)
+	switch {
+	case matchesValue && !f.Sensitive:
+		return appendIndexedField(b, idx), nil
+	case nameFound:
+		return appendLiteralFieldWithNameReference(b, f, idx, e.huffman), nil
+	default:
+		return appendLiteralFieldWithoutNameReference(b, f, e.huffman), nil
+	}
+}
+
+func (e *Encoder) appendFieldDynamic(b []byte, f HeaderField) ([]byte, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.section.started {
+		e.section = encoderSection{
+			started: true,
+			base:    e.table.knownReceivedCount,
+			minRef:  noRef,
+		}
+	}
+
+	// Sensitive header fields are only encoded using literal representations,
+	// and they are never inserted into the dynamic table.
+	idx, matchesValue, nameFound := lookupStatic(f)
+	if matchesValue && !f.Sensitive {
+		return appendIndexedField(b, idx), nil
+	}
+	absIndex, matchesDynamicValue, dynamicFound := e.table.lookup(f.Name, f.Value, e.section.base)
+	if dynamicFound && matchesDynamicValue && !f.Sensitive {
+		e.reference(absIndex)
+		return appendIndexedDynamicField(b, e.section.base-1-absIndex), nil
+	}
+	// Reference the entry before inserting, so that the insertion can't evict it.
+	useDynamicName := dynamicFound && !nameFound
+	if useDynamicName {
+		e.reference(absIndex)
+	}
+	if !f.Sensitive {
+		if err := e.maybeInsert(f); err != nil {
+			return b, err
+		}
+	}
+	switch {
+	case nameFound:
+		return appendLiteralFieldWithNameReference(b, f, idx, e.huffman), nil
+	case useDynamicName:
+		return appendLiteralFieldWithDynamicNameReference(b, f, e.section.base-1-absIndex, e.huffman), nil
+	default:
+		return appendLiteralFieldWithoutNameReference(b, f, e.huffman), nil
+	}
+}
+
+// reference records that the current field section references a dynamic table entry.
+func (e *Encoder) reference(absIndex uint64) {
+	e.section.requiredInsertCount = max(e.section.requiredInsertCount, absIndex+1)
+	e.section.minRef = min(e.section.minRef, absIndex)
+}
+
+// maybeInsert inserts f into the dynamic table, if it's worth it and there's enough space.
+// The entry can only be referenced once the decoder acknowledges the insertion.
+// The caller must hold the mutex. It is released while writing to the encoder stream,
+// so that HandleDecoderStream isn't blocked by a slow encoder stream.
+// This doesn't invalidate the decision to insert: entries are only inserted and evicted by the encoding goroutine,
+// and instructions received on the decoder stream in the meantime can only make room for more entries.
+func (e *Encoder) maybeInsert(f HeaderField) error {
+	size := entrySize(f.Name, f.Value)
+	// Large entries would evict many other entries.
+	if size > e.maxTableCapacity/2 || e.table.contains(f.Name, f.Value) {
+		return nil
+	}
+	e.instructionBuf = e.instructionBuf[:0]
+	setCapacity := e.table.capacity != e.maxTableCapacity
+	if setCapacity {
+		e.instructionBuf = wire.AppendSetDynamicTableCapacity(e.instructionBuf, e.maxTableCapacity)
+	} else if !e.table.canInsert(size, e.section.minRef) {
+		return nil
+	}
+	if idx, _, ok := lookupStatic(f); ok {
+		e.instructionBuf = wire.AppendInsertWithNameReference(e.instructionBuf, true, uint64(idx), f.Value, e.huffman.use(f.Value))
+	} else {
+		e.instructionBuf = wire.AppendInsertWithLiteralName(e.instructionBuf, f.Name, e.huffman.use(f.Name), f.Value, e.huffman.use(f.Value))
+	}
+	e.mutex.Unlock()
+	_, err := e.encoderStream.Write(e.instructionBuf)
+	e.mutex.Lock()
+	if err != nil {
+		return err
+	}
+	if setCapacity {
+		e.table.setCapacity(e.maxTableCapacity)
+	}
+	e.table.insert(f)
+	return nil
 }
 
 // Close declares that the encoding is complete and resets the Encoder
 // to be reused again for a new header block.
 func (e *Encoder) Close() error {
 	e.wrotePrefix = false
+	e.sectionSize = 0
+	if e.table == nil || !e.section.started {
+		return nil
+	}
+
+	e.prefixBuf = e.appendSection(e.prefixBuf[:0])
+	_, err := e.w.Write(e.prefixBuf)
+	return err
+}
+
+// appendSection completes the current field section when the dynamic table is used.
+// It appends the Encoded Field Section Prefix followed by the encoded field lines to b.
+func (e *Encoder) appendSection(b []byte) []byte {
+	section := e.section
+	e.section = encoderSection{}
+	b = e.appendFieldSectionPrefix(b, section)
+	b = append(b, e.buf...)
+	e.buf = e.buf[:0]
+	// The field section needs to be recorded before it is sent,
+	// otherwise the acknowledgment might be received before.
+	if section.requiredInsertCount > 0 {
+		e.mutex.Lock()
+		e.table.sectionSent(e.streamID, section.requiredInsertCount, section.minRef)
+		e.mutex.Unlock()
+	}
+	return b
+}
+
+// HandleDecoderStream processes data received on the peer's decoder stream.
+// Instructions don't need to be aligned with the boundaries of p:
+// Incomplete instructions are buffered until the remaining bytes are received.
+// Any error returned is an *Error with the code ErrCodeDecoderStreamError.
+func (e *Encoder) HandleDecoderStream(p []byte) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.decoderStreamBuf) > 0 {
+		e.decoderStreamBuf = append(e.decoderStreamBuf, p...)
+		p = e.decoderStreamBuf
+	}
+	for len(p) > 0 {
+		rest, err := e.parseDecoderInstruction(p)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return &Error{Code: ErrCodeDecoderStreamError, Err: err}
+		}
+		p = rest
+	}
+	e.decoderStreamBuf = append(e.decoderStreamBuf[:0], p...)
 	return nil
 }
 
-func (e *Encoder) writeLiteralFieldWithoutNameReference(f HeaderField) {
-	offset := len(e.buf)
-	e.buf = appendVarInt(e.buf, 3, hpack.HuffmanEncodeLength(f.Name))
-	e.buf[offset] ^= 0x20 ^ 0x8
-	e.buf = hpack.AppendHuffmanString(e.buf, f.Name)
-	offset = len(e.buf)
-	e.buf = appendVarInt(e.buf, 7, hpack.HuffmanEncodeLength(f.Value))
-	e.buf[offset] ^= 0x80
-	e.buf = hpack.AppendHuffmanString(e.buf, f.Value)
+// parseDecoderInstruction parses and applies a single decoder instruction,
+// see Section 4.4 of RFC 9204.
+// It returns io.ErrUnexpectedEOF if p doesn't contain the complete instruction.
+func (e *Encoder) parseDecoderInstruction(p []byte) (rest []byte, _ error) {
+	b := p[0]
+	switch {
+	case b&0x80 > 0: // 1xxxxxxx: Section Acknowledgment
+		streamID, rest, err := wire.ReadVarInt(7, p)
+		if err != nil {
+			return p, err
+		}
+		if e.table == nil {
+			return p, errUnknownSection
+		}
+		return rest, e.table.onSectionAcknowledgment(streamID)
+	case b&0x40 > 0: // 01xxxxxx: Stream Cancellation
+		streamID, rest, err := wire.ReadVarInt(6, p)
+		if err != nil {
+			return p, err
+		}
+		// The decoder might cancel streams even if the dynamic table is not used.
+		if e.table != nil {
+			e.table.onStreamCancellation(streamID)
+		}
+		return rest, nil
+	default: // 00xxxxxx: Insert Count Increment
+		increment, rest, err := wire.ReadVarInt(6, p)
+		if err != nil {
+			return p, err
+		}
+		if e.table == nil {
+			if increment == 0 {
+				return p, errInvalidIncrement
+			}
+			return p, errIncrementBeyondInsert
+		}
+		return rest, e.table.onInsertCountIncrement(increment)
+	}
 }
 
-// Encodes a header field whose name is present in one of the tables.
-func (e *Encoder) writeLiteralFieldWithNameReference(f *HeaderField, id uint8) {
-	offset := len(e.buf)
-	e.buf = appendVarInt(e.buf, 4, uint64(id))
-	// Set the 01NTxxxx pattern, forcing N to 0 and T to 1
-	e.buf[offset] ^= 0x50
-	offset = len(e.buf)
-	e.buf = appendVarInt(e.buf, 7, hpack.HuffmanEncodeLength(f.Value))
-	e.buf[offset] ^= 0x80
-	e.buf = hpack.AppendHuffmanString(e.buf, f.Value)
+// appendFieldSectionPrefix appends the Encoded Field Section Prefix, see Section 4.5.1 of RFC 9204.
+func (e *Encoder) appendFieldSectionPrefix(b []byte, section encoderSection) []byte {
+	if section.requiredInsertCount == 0 {
+		return wire.AppendFieldSectionPrefix(b, wire.FieldSectionPrefix{})
+	}
+	// The Base is never smaller than the Required Insert Count, so the sign bit is always 0.
+	return wire.AppendFieldSectionPrefix(b, wire.FieldSectionPrefix{
+		EncodedInsertCount: wire.EncodeRequiredInsertCount(section.requiredInsertCount, e.maxTableCapacity),
+		DeltaBase:          section.base - section.requiredInsertCount,
+	})
+}
+
+// lookupStatic looks up f in the static table.
+// If the static table doesn't contain an entry matching both name and value,
+// the index of an entry matching the name is returned.
+func lookupStatic(f HeaderField) (idx uint8, matchesValue, nameFound bool) {
+	idxAndVals, nameFound := encoderMap[f.Name]
+	if !nameFound {
+		return 0, false, false
+	}
+	if idxAndVals.values == nil {
+		return idxAndVals.idx, len(f.Value) == 0, true
+	}
+	if valIdx, valueFound := idxAndVals.values[f.Value]; valueFound {
+		return valIdx, true, true
+	}
+	return idxAndVals.idx, false, true
+}
+
+// Encodes a header field whose name is not present in one of the tables.
+func appendLiteralFieldWithoutNameReference(b []byte, f HeaderField, huffman HuffmanPolicy) []byte {
+	return wire.AppendLiteralFieldLineWithLiteralName(b, f.Sensitive, f.Name, huffman.use(f.Name), f.Value, huffman.use(f.Value))
+}
+
+// Encodes a header field whose name is present in the static table.
+func appendLiteralFieldWithNameReference(b []byte, f HeaderField, id uint8, huffman HuffmanPolicy) []byte {
+	return wire.AppendLiteralFieldLineWithNameReference(b, f.Sensitive, true, uint64(id), f.Value, huffman.use(f.Value))
+}
+
+// Encodes a header field whose name is present in the dynamic table.
+func appendLiteralFieldWithDynamicNameReference(b []byte, f HeaderField, relIndex uint64, huffman HuffmanPolicy) []byte {
+	return wire.AppendLiteralFieldLineWithNameReference(b, f.Sensitive, false, relIndex, f.Value, huffman.use(f.Value))
 }
 
 // Encodes an indexed field, meaning it's entirely defined in one of the tables.
-func (e *Encoder) writeIndexedField(id uint8) {
-	offset := len(e.buf)
-	e.buf = appendVarInt(e.buf, 6, uint64(id))
-	// Set the 1Txxxxxx pattern, forcing T to 1
-	e.buf[offset] ^= 0xc0
+func appendIndexedField(b []byte, id uint8) []byte {
+	return wire.AppendIndexedFieldLine(b, true, uint64(id))
+}
+
+// Encodes an indexed field that is entirely defined in the dynamic table.
+func appendIndexedDynamicField(b []byte, relIndex uint64) []byte {
+	return wire.AppendIndexedFieldLine(b, false, relIndex)
+}
+
+// use reports whether s should be Huffman-encoded.
+func (p HuffmanPolicy) use(s string) bool {
+	switch p {
+	case HuffmanAlways:
+		return true
+	case HuffmanNever:
+		return false
+	default:
+		return hpack.HuffmanEncodeLength(s) < uint64(len(s))
+	}
 }
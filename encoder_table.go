@@ -0,0 +1,162 @@
+package qpack
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	errUnknownSection        = errors.New("acknowledgment for a stream without outstanding field sections")
+	errInvalidIncrement      = errors.New("invalid Insert Count Increment")
+	errIncrementBeyondInsert = errors.New("received an Insert Count Increment beyond the number of inserts")
+)
+
+// fieldKey is used to look up dynamic table entries by name and value.
+type fieldKey struct {
+	name, value string
+}
+
+// An unackedSection is a field section that references the dynamic table,
+// and hasn't been acknowledged by the decoder yet.
+type unackedSection struct {
+	requiredInsertCount uint64
+	minRef              uint64 // smallest absolute index referenced by the field section
+}
+
+// The encoderTable is the encoder's view of the dynamic table.
+// It keeps track of which entries the decoder has received,
+// and which entries are still referenced by unacknowledged field sections.
+// Only entries that are neither unacknowledged nor referenced can be evicted,
+// see Section 2.1.1 of RFC 9204.
+type encoderTable struct {
+	dynamicTable
+
+	fields map[fieldKey]uint64 // absolute index of the most recent entry for every name-value pair
+	names  map[string]uint64   // absolute index of the most recent entry for every name
+
+	knownReceivedCount uint64
+	unacked            map[uint64][]unackedSection // by stream ID, in the order the sections were sent
+	// a slice that was used to track unacknowledged sections on a stream that are all acknowledged now,
+	// reused in order to avoid allocating for every field section
+	spareUnacked []unackedSection
+}
+
+func newEncoderTable() *encoderTable {
+	return &encoderTable{
+		fields:  make(map[fieldKey]uint64),
+		names:   make(map[string]uint64),
+		unacked: make(map[uint64][]unackedSection),
+	}
+}
+
+// lookup finds an entry that has been acknowledged by the decoder, and was inserted before base.
+// If there's no entry matching both name and value, it returns an entry matching the name.
+func (t *encoderTable) lookup(name, value string, base uint64) (absIndex uint64, matchesValue, ok bool) {
+	if absIndex, ok := t.fields[fieldKey{name: name, value: value}]; ok && absIndex < base {
+		return absIndex, true, true
+	}
+	if absIndex, ok := t.names[name]; ok && absIndex < base {
+		return absIndex, false, true
+	}
+	return 0, false, false
+}
+
+func (t *encoderTable) contains(name, value string) bool {
+	_, ok := t.fields[fieldKey{name: name, value: value}]
+	return ok
+}
+
+// canInsert says if an entry of the given size can be inserted
+// without evicting entries that are blocking eviction.
+// minRef is the smallest absolute index referenced by the field section currently being encoded.
+func (t *encoderTable) canInsert(size, minRef uint64) bool {
+	if size > t.capacity {
+		return false
+	}
+	evictable := min(t.knownReceivedCount, minRef)
+	for _, sections := range t.unacked {
+		for _, s := range sections {
+			evictable = min(evictable, s.minRef)
+		}
+	}
+	available := t.capacity - t.size
+	for i := t.evicted; available < size; i++ {
+		if i >= evictable {
+			return false
+		}
+		hf, _ := t.get(i)
+		available += entrySize(hf.Name, hf.Value)
+	}
+	return true
+}
+
+// insert inserts a new entry. The caller must check that the entry can be inserted using canInsert.
+func (t *encoderTable) insert(hf HeaderField) {
+	size := entrySize(hf.Name, hf.Value)
+	for t.size+size > t.capacity {
+		oldest := t.entries[0]
+		key := fieldKey{name: oldest.Name, value: oldest.Value}
+		if t.fields[key] == t.evicted {
+			delete(t.fields, key)
+		}
+		if t.names[oldest.Name] == t.evicted {
+			delete(t.names, oldest.Name)
+		}
+		t.evictOldest()
+	}
+	absIndex := t.insertCount()
+	t.entries = append(t.entries, hf)
+	t.size += size
+	t.fields[fieldKey{name: hf.Name, value: hf.Value}] = absIndex
+	t.names[hf.Name] = absIndex
+}
+
+// sectionSent records a field section that references the dynamic table.
+func (t *encoderTable) sectionSent(streamID, requiredInsertCount, minRef uint64) {
+	sections, ok := t.unacked[streamID]
+	if !ok {
+		sections = t.spareUnacked
+		t.spareUnacked = nil
+	}
+	t.unacked[streamID] = append(sections, unackedSection{
+		requiredInsertCount: requiredInsertCount,
+		minRef:              minRef,
+	})
+}
+
+// onSectionAcknowledgment handles a Section Acknowledgment instruction.
+// It acknowledges the oldest unacknowledged field section sent on the stream.
+func (t *encoderTable) onSectionAcknowledgment(streamID uint64) error {
+	sections, ok := t.unacked[streamID]
+	if !ok {
+		return errUnknownSection
+	}
+	t.knownReceivedCount = max(t.knownReceivedCount, sections[0].requiredInsertCount)
+	if len(sections) == 1 {
+		delete(t.unacked, streamID)
+		t.spareUnacked = sections[:0]
+	} else {
+		t.unacked[streamID] = sections[1:]
+	}
+	return nil
+}
+
+// onStreamCancellation handles a Stream Cancellation instruction.
+func (t *encoderTable) onStreamCancellation(streamID uint64) {
+	delete(t.unacked, streamID)
+}
+
+// onInsertCountIncrement handles an Insert Count Increment instruction.
+func (t *encoderTable) onInsertCountIncrement(increment uint64) error {
+	if increment == 0 {
+		return errInvalidIncrement
+	}
+	if increment > t.insertCount()-t.knownReceivedCount {
+		return errIncrementBeyondInsert
+	}
+	t.knownReceivedCount += increment
+	return nil
+}
+
+// noRef is used as the smallest referenced index of a field section that doesn't reference the dynamic table.
+const noRef = math.MaxUint64
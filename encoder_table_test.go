@@ -0,0 +1,70 @@
+package qpack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoderTableAcknowledgments(t *testing.T) {
+	table := newEncoderTable()
+	table.setCapacity(1000)
+	for _, name := range []string{"foo", "bar", "baz"} {
+		require.True(t, table.canInsert(entrySize(name, "value"), noRef))
+		table.insert(HeaderField{Name: name, Value: "value"})
+	}
+
+	require.ErrorIs(t, table.onInsertCountIncrement(0), errInvalidIncrement)
+	require.ErrorIs(t, table.onInsertCountIncrement(4), errIncrementBeyondInsert)
+	require.NoError(t, table.onInsertCountIncrement(1))
+	require.Equal(t, uint64(1), table.knownReceivedCount)
+
+	table.sectionSent(4, 2, 1)
+	table.sectionSent(4, 3, 0)
+	require.NoError(t, table.onSectionAcknowledgment(4))
+	require.Equal(t, uint64(2), table.knownReceivedCount)
+	require.NoError(t, table.onSectionAcknowledgment(4))
+	require.Equal(t, uint64(3), table.knownReceivedCount)
+	require.ErrorIs(t, table.onSectionAcknowledgment(4), errUnknownSection)
+	require.ErrorIs(t, table.onInsertCountIncrement(1), errIncrementBeyondInsert)
+
+	table.sectionSent(8, 3, 2)
+	table.onStreamCancellation(8)
+	require.ErrorIs(t, table.onSectionAcknowledgment(8), errUnknownSection)
+}
+
+func TestEncoderTableLookup(t *testing.T) {
+	table := newEncoderTable()
+	table.setCapacity(1000)
+	table.insert(HeaderField{Name: "foo", Value: "bar"})
+	table.insert(HeaderField{Name: "foo", Value: "baz"})
+
+	absIndex, matchesValue, ok := table.lookup("foo", "bar", 2)
+	require.True(t, ok)
+	require.True(t, matchesValue)
+	require.Zero(t, absIndex)
+	absIndex, matchesValue, ok = table.lookup("foo", "lorem", 2)
+	require.True(t, ok)
+	require.False(t, matchesValue)
+	require.Equal(t, uint64(1), absIndex)
+	// entries inserted at or after the base are not returned
+	_, _, ok = table.lookup("foo", "baz", 1)
+	require.False(t, ok)
+	_, _, ok = table.lookup("lorem", "ipsum", 2)
+	require.False(t, ok)
+}
+
+func TestEncoderTableEvictionRemovesLookupEntries(t *testing.T) {
+	table := newEncoderTable()
+	table.setCapacity(80)
+	table.insert(HeaderField{Name: "foo", Value: "bar"})
+	table.insert(HeaderField{Name: "foo", Value: "baz"})
+	require.NoError(t, table.onInsertCountIncrement(2))
+	require.True(t, table.canInsert(entrySize("lorem", "ipsum"), noRef))
+	require.False(t, table.canInsert(entrySize("lorem", "ipsum"), 0)) // the first entry is still referenced
+	table.insert(HeaderField{Name: "lorem", Value: "ipsum"})
+	require.False(t, table.contains("foo", "bar"))
+	require.True(t, table.contains("foo", "baz"))
+	_, _, ok := table.lookup("foo", "bar", 3)
+	require.True(t, ok) // name reference to the second entry
+}
@@ -2,8 +2,12 @@ package qpack
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"testing"
+	"time"
+
+	"github.com/quic-go/qpack/wire"
 
 	"golang.org/x/net/http2/hpack"
 
@@ -24,40 +28,52 @@ func (ew *errWriter) Write(b []byte) (int, error) {
 	return ew.Buffer.Write(b)
 }
 
+// blockingWriter wraps bytes.Buffer, and blocks every write until unblock is closed
+// useful for testing that no lock is held while writing to a stream
+type blockingWriter struct {
+	bytes.Buffer
+	writing chan struct{} // receives a value once a write is blocked
+	unblock chan struct{}
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{writing: make(chan struct{}, 1), unblock: make(chan struct{})}
+}
+
+func (bw *blockingWriter) Write(b []byte) (int, error) {
+	select {
+	case bw.writing <- struct{}{}:
+	default:
+	}
+	<-bw.unblock
+	return bw.Buffer.Write(b)
+}
+
 func readPrefix(t *testing.T, data []byte) (rest []byte, requiredInsertCount uint64, deltaBase uint64) {
 	var err error
-	requiredInsertCount, rest, err = readVarInt(8, data)
+	requiredInsertCount, rest, err = wire.ReadVarInt(8, data)
 	require.NoError(t, err)
-	deltaBase, rest, err = readVarInt(7, rest)
+	deltaBase, rest, err = wire.ReadVarInt(7, rest)
 	require.NoError(t, err)
 	return
 }
 
 func checkHeaderField(t *testing.T, data []byte, hf HeaderField) []byte {
 	require.Equal(t, uint8(0x20), data[0]&(0x80^0x40^0x20)) // 001xxxxx
-	require.NotZero(t, data[0]&0x8)                         // Huffman encoding
-	nameLen, data, err := readVarInt(3, data)
+	name, data, err := readString(3, data)
 	require.NoError(t, err)
-	l := hpack.HuffmanEncodeLength(hf.Name)
-	require.Equal(t, l, nameLen)
-	decodedName, err := hpack.HuffmanDecodeToString(data[:l])
+	require.Equal(t, hf.Name, name)
+	value, data, err := readString(7, data)
 	require.NoError(t, err)
-	require.Equal(t, hf.Name, decodedName)
-	valueLen, data, err := readVarInt(7, data[l:])
-	require.NoError(t, err)
-	l = hpack.HuffmanEncodeLength(hf.Value)
-	require.Equal(t, l, valueLen)
-	decodedValue, err := hpack.HuffmanDecodeToString(data[:l])
-	require.NoError(t, err)
-	require.Equal(t, hf.Value, decodedValue)
-	return data[l:]
+	require.Equal(t, hf.Value, value)
+	return data
 }
 
 // Reads one indexed field line representation from data and verifies it matches hf.
 // Returns the leftover bytes from data.
 func checkIndexedHeaderField(t *testing.T, data []byte, hf HeaderField) []byte {
 	require.Equal(t, uint8(1), data[0]>>7) // 1Txxxxxx
-	index, data, err := readVarInt(6, data)
+	index, data, err := wire.ReadVarInt(6, data)
 	require.NoError(t, err)
 	require.Equal(t, hf, staticTableEntries[index])
 	return data
@@ -66,18 +82,14 @@ func checkIndexedHeaderField(t *testing.T, data []byte, hf HeaderField) []byte {
 func checkHeaderFieldWithNameRef(t *testing.T, data []byte, hf HeaderField) []byte {
 	// read name reference
 	require.Equal(t, uint8(1), data[0]>>6) // 01NTxxxx
-	index, data, err := readVarInt(4, data)
+	index, data, err := wire.ReadVarInt(4, data)
 	require.NoError(t, err)
 	require.Equal(t, hf.Name, staticTableEntries[index].Name)
 	// read literal value
-	valueLen, data, err := readVarInt(7, data)
+	value, data, err := readString(7, data)
 	require.NoError(t, err)
-	l := hpack.HuffmanEncodeLength(hf.Value)
-	require.Equal(t, l, valueLen)
-	decodedValue, err := hpack.HuffmanDecodeToString(data[:l])
-	require.NoError(t, err)
-	require.Equal(t, hf.Value, decodedValue)
-	return data[l:]
+	require.Equal(t, hf.Value, value)
+	return data
 }
 
 func TestEncoderEncodesSingleField(t *testing.T) {
@@ -181,3 +193,543 @@ func TestEncodeMultipleRequests(t *testing.T) {
 	require.Zero(t, deltaBase)
 	require.Empty(t, checkHeaderField(t, data, hf2))
 }
+
+func TestEncoderDynamicTable(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 4096))
+	decoder := NewDecoder(WithMaxTableCapacity(4096))
+
+	hfs := []HeaderField{
+		{Name: ":method", Value: "GET"}, // in the static table
+		{Name: ":authority", Value: "quic-go.net"},
+		{Name: "user-agent", Value: "quic-go HTTP/3"},
+		{Name: "x-custom", Value: "lorem ipsum"},
+	}
+	for _, hf := range hfs {
+		require.NoError(t, encoder.WriteField(hf))
+	}
+	require.Empty(t, output.Bytes()) // nothing is written before the header block is complete
+	require.NoError(t, encoder.Close())
+
+	// the header fields were inserted, but they're not referenced before the decoder acknowledges them
+	_, requiredInsertCount, _ := readPrefix(t, output.Bytes())
+	require.Zero(t, requiredInsertCount)
+	require.NoError(t, decoder.HandleEncoderStream(encoderStream.Bytes()))
+	require.Equal(t, uint64(3), decoder.table.insertCount())
+	require.Equal(t, hfs, decodeAll(t, decoder.Decode(output.Bytes())))
+	firstLen := output.Len()
+
+	require.NoError(t, encoder.table.onInsertCountIncrement(3))
+
+	output.Reset()
+	encoderStream.Reset()
+	for _, hf := range hfs {
+		require.NoError(t, encoder.WriteField(hf))
+	}
+	require.NoError(t, encoder.Close())
+	require.Empty(t, encoderStream.Bytes()) // no new insertions
+	_, requiredInsertCount, deltaBase := readPrefix(t, output.Bytes())
+	require.Equal(t, uint64(3+1), requiredInsertCount) // encoded Required Insert Count
+	require.Zero(t, deltaBase)
+	require.Equal(t, hfs, decodeAll(t, decoder.DecodeStream(0, output.Bytes())))
+	require.Less(t, output.Len(), firstLen)
+	t.Logf("Encoding header fields: %d bytes without, %d bytes with dynamic table", firstLen, output.Len())
+}
+
+func TestEncoderDynamicTableNameReference(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 4096))
+	decoder := NewDecoder(WithMaxTableCapacity(4096))
+
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "x-custom", Value: "foo"}))
+	require.NoError(t, encoder.Close())
+	require.NoError(t, decoder.HandleEncoderStream(encoderStream.Bytes()))
+	require.NoError(t, encoder.table.onInsertCountIncrement(1))
+
+	output.Reset()
+	hf := HeaderField{Name: "x-custom", Value: "bar"}
+	require.NoError(t, encoder.WriteField(hf))
+	require.NoError(t, encoder.Close())
+	data, requiredInsertCount, _ := readPrefix(t, output.Bytes())
+	require.Equal(t, uint64(1+1), requiredInsertCount)
+	require.Equal(t, uint8(0x40), data[0]&0xf0) // 01NTxxxx, with T = 0
+	require.Equal(t, []HeaderField{hf}, decodeAll(t, decoder.DecodeStream(0, output.Bytes())))
+}
+
+func TestEncoderDynamicTableEviction(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	// room for 2 entries of size 38
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 100))
+
+	writeSection := func(streamID uint64, hfs ...HeaderField) {
+		t.Helper()
+		encoderStream.Reset()
+		output.Reset()
+		encoder.SetStreamID(streamID)
+		for _, hf := range hfs {
+			require.NoError(t, encoder.WriteField(hf))
+		}
+		require.NoError(t, encoder.Close())
+	}
+
+	hf1 := HeaderField{Name: "aaa", Value: "111"}
+	hf2 := HeaderField{Name: "bbb", Value: "222"}
+	hf3 := HeaderField{Name: "ccc", Value: "333"}
+	writeSection(4, hf1)
+	require.NoError(t, encoder.table.onInsertCountIncrement(1))
+	// the field section on stream 8 references hf1, and inserts hf2
+	writeSection(8, hf1, hf2)
+	_, requiredInsertCount, _ := readPrefix(t, output.Bytes())
+	require.NotZero(t, requiredInsertCount)
+	require.NoError(t, encoder.table.onInsertCountIncrement(1))
+
+	// inserting hf3 would evict hf1, which is referenced by an unacknowledged field section
+	writeSection(12, hf3)
+	require.Empty(t, encoderStream.Bytes())
+	require.Equal(t, uint64(2), encoder.table.insertCount())
+
+	require.NoError(t, encoder.table.onSectionAcknowledgment(8))
+	writeSection(16, hf3)
+	require.NotEmpty(t, encoderStream.Bytes())
+	require.Equal(t, uint64(3), encoder.table.insertCount())
+	require.False(t, encoder.table.contains(hf1.Name, hf1.Value))
+}
+
+func TestEncoderDynamicTableUnacknowledgedEntriesAreNotEvicted(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 100))
+
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "aaa", Value: "111"}))
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "bbb", Value: "222"}))
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "ccc", Value: "333"}))
+	require.NoError(t, encoder.Close())
+	require.Equal(t, uint64(2), encoder.table.insertCount())
+}
+
+func TestEncoderDynamicTableLargeFields(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 100))
+
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "foo", Value: randomString(20)}))
+	require.NoError(t, encoder.Close())
+	require.Empty(t, encoderStream.Bytes())
+	require.Zero(t, encoder.table.insertCount())
+}
+
+func TestEncoderDynamicTableFailsWhenEncoderStreamErrs(t *testing.T) {
+	encoderStream := &errWriter{fail: true}
+	encoder := NewEncoder(&bytes.Buffer{}, WithEncoderStream(encoderStream, 4096))
+
+	err := encoder.WriteField(HeaderField{Name: "foobar", Value: "lorem ipsum"})
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+	require.Zero(t, encoder.table.insertCount())
+}
+
+func TestEncoderDynamicTableDisabledForSmallCapacity(t *testing.T) {
+	encoder := NewEncoder(&bytes.Buffer{}, WithEncoderStream(&bytes.Buffer{}, entryOverhead-1))
+	require.Nil(t, encoder.table)
+}
+
+func TestEncoderHandleDecoderStream(t *testing.T) {
+	var encoderStream, decoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 4096))
+	decoder := NewDecoder(WithMaxTableCapacity(4096), WithDecoderStream(&decoderStream))
+
+	hfs := []HeaderField{
+		{Name: ":authority", Value: "quic-go.net"},
+		{Name: "user-agent", Value: "quic-go HTTP/3"},
+	}
+	var lengths []int
+	for i := range 3 {
+		streamID := uint64(4 * i)
+		output.Reset()
+		encoder.SetStreamID(streamID)
+		for _, hf := range hfs {
+			require.NoError(t, encoder.WriteField(hf))
+		}
+		require.NoError(t, encoder.Close())
+		lengths = append(lengths, output.Len())
+
+		require.NoError(t, decoder.HandleEncoderStream(encoderStream.Bytes()))
+		encoderStream.Reset()
+		require.Equal(t, hfs, decodeAll(t, decoder.DecodeStream(streamID, output.Bytes())))
+		// pass the decoder stream to the encoder byte by byte
+		for _, b := range decoderStream.Bytes() {
+			require.NoError(t, encoder.HandleDecoderStream([]byte{b}))
+		}
+		decoderStream.Reset()
+	}
+	require.Less(t, lengths[1], lengths[0])
+	require.Equal(t, lengths[1], lengths[2])
+	require.Equal(t, uint64(2), encoder.table.knownReceivedCount)
+	require.Empty(t, encoder.table.unacked)
+	require.Empty(t, encoder.decoderStreamBuf)
+}
+
+func TestEncoderHandleDecoderStreamWhileWritingEncoderStream(t *testing.T) {
+	encoderStream := newBlockingWriter()
+	encoder := NewEncoder(&bytes.Buffer{}, WithEncoderStream(encoderStream, 4096))
+	errChan := make(chan error, 1)
+	go func() { errChan <- encoder.WriteField(HeaderField{Name: "foo", Value: "bar"}) }()
+
+	select {
+	case <-encoderStream.writing:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	// the mutex isn't held while the insertion is written to the encoder stream
+	handleErrChan := make(chan error, 1)
+	go func() { handleErrChan <- encoder.HandleDecoderStream(wire.AppendStreamCancellation(nil, 4)) }()
+	select {
+	case err := <-handleErrChan:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("HandleDecoderStream blocked by the encoder stream")
+	}
+
+	close(encoderStream.unblock)
+	select {
+	case err := <-errChan:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	require.NoError(t, encoder.Close())
+	require.Equal(t, uint64(1), encoder.table.insertCount())
+}
+
+func TestEncoderHandleDecoderStreamStreamCancellation(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 4096))
+
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "foo", Value: "bar"}))
+	require.NoError(t, encoder.Close())
+	require.NoError(t, encoder.HandleDecoderStream(wire.AppendInsertCountIncrement(nil, 1)))
+	encoder.SetStreamID(8)
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "foo", Value: "bar"}))
+	require.NoError(t, encoder.Close())
+	require.Contains(t, encoder.table.unacked, uint64(8))
+
+	require.NoError(t, encoder.HandleDecoderStream(wire.AppendStreamCancellation(nil, 8)))
+	require.Empty(t, encoder.table.unacked)
+}
+
+func TestEncoderHandleDecoderStreamErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{
+			name:     "unknown stream",
+			input:    wire.AppendSectionAcknowledgment(nil, 4),
+			expected: errUnknownSection,
+		},
+		{
+			name:     "zero increment",
+			input:    wire.AppendInsertCountIncrement(nil, 0),
+			expected: errInvalidIncrement,
+		},
+		{
+			name:     "increment beyond inserts",
+			input:    wire.AppendInsertCountIncrement(nil, 2),
+			expected: errIncrementBeyondInsert,
+		},
+		{
+			name:     "varint overflow",
+			input:    []byte{0x3f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			expected: wire.ErrVarintOverflow,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, dynamicTable := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s, dynamic table: %t", tt.name, dynamicTable), func(t *testing.T) {
+				var opts []EncoderOption
+				if dynamicTable {
+					opts = append(opts, WithEncoderStream(&bytes.Buffer{}, 4096))
+				}
+				encoder := NewEncoder(&bytes.Buffer{}, opts...)
+				if dynamicTable {
+					// insert one entry
+					require.NoError(t, encoder.WriteField(HeaderField{Name: "foo", Value: "bar"}))
+					require.NoError(t, encoder.Close())
+				}
+
+				err := encoder.HandleDecoderStream(tt.input)
+				require.ErrorIs(t, err, tt.expected)
+				var qerr *Error
+				require.ErrorAs(t, err, &qerr)
+				require.Equal(t, ErrCodeDecoderStreamError, qerr.Code)
+			})
+		}
+	}
+}
+
+func TestEncoderHandleDecoderStreamWithoutDynamicTable(t *testing.T) {
+	encoder := NewEncoder(&bytes.Buffer{})
+	require.NoError(t, encoder.HandleDecoderStream(wire.AppendStreamCancellation(nil, 4)))
+}
+
+func TestEncoderSensitiveHeaderFields(t *testing.T) {
+	hfs := []HeaderField{
+		{Name: ":method", Value: "GET", Sensitive: true},          // matches a static table entry
+		{Name: "authorization", Value: "secret", Sensitive: true}, // matches a static table name
+		{Name: "x-secret", Value: "foobar", Sensitive: true},
+	}
+
+	t.Run("without dynamic table", func(t *testing.T) {
+		encoder, output := getEncoder()
+		for _, hf := range hfs {
+			require.NoError(t, encoder.WriteField(hf))
+		}
+		data, _, _ := readPrefix(t, output.Bytes())
+		require.Equal(t, uint8(0x40|0x20), data[0]&0xe0) // 01NTxxxx, with N = 1
+		require.Equal(t, hfs, decodeAll(t, NewDecoder().Decode(output.Bytes())))
+	})
+
+	t.Run("with dynamic table", func(t *testing.T) {
+		var encoderStream, output bytes.Buffer
+		encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 4096))
+		for _, hf := range hfs {
+			require.NoError(t, encoder.WriteField(hf))
+		}
+		require.NoError(t, encoder.Close())
+		require.Empty(t, encoderStream.Bytes()) // sensitive header fields are not inserted
+		require.Equal(t, hfs, decodeAll(t, NewDecoder().Decode(output.Bytes())))
+	})
+}
+
+func TestEncoderSensitivityPolicy(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output,
+		WithEncoderStream(&encoderStream, 4096),
+		WithSensitivityPolicy(DefaultSensitivityPolicy),
+	)
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "authorization", Value: "Bearer foobar"}))
+	require.NoError(t, encoder.WriteField(HeaderField{Name: "user-agent", Value: "quic-go"}))
+	require.NoError(t, encoder.Close())
+	require.Equal(t, uint64(1), encoder.table.insertCount())
+	require.Equal(t,
+		[]HeaderField{
+			{Name: "authorization", Value: "Bearer foobar", Sensitive: true},
+			{Name: "user-agent", Value: "quic-go"},
+		},
+		decodeAll(t, NewDecoder().Decode(output.Bytes())),
+	)
+}
+
+func TestEncoderPeerMaxFieldSectionSize(t *testing.T) {
+	hf1 := HeaderField{Name: "foo", Value: "bar"}     // 38 bytes
+	hf2 := HeaderField{Name: "lorem", Value: "ipsum"} // 42 bytes
+
+	t.Run("static table", func(t *testing.T) {
+		testEncoderPeerMaxFieldSectionSize(t, nil, hf1, hf2)
+	})
+
+	t.Run("dynamic table", func(t *testing.T) {
+		testEncoderPeerMaxFieldSectionSize(t, []EncoderOption{WithEncoderStream(io.Discard, 4096)}, hf1, hf2)
+	})
+}
+
+func testEncoderPeerMaxFieldSectionSize(t *testing.T, opts []EncoderOption, hf1, hf2 HeaderField) {
+	var output bytes.Buffer
+	encoder := NewEncoder(&output, append(opts, WithPeerMaxFieldSectionSize(38+42-1))...)
+
+	require.NoError(t, encoder.WriteField(hf1))
+	outputLen := output.Len()
+	err := encoder.WriteField(hf2)
+	require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+	var sizeErr *FieldSectionTooLargeError
+	require.ErrorAs(t, err, &sizeErr)
+	require.Equal(t, uint64(38+42), sizeErr.Size)
+	require.Equal(t, uint64(38+42-1), sizeErr.Limit)
+	require.EqualError(t, err, "field section size of 80 bytes exceeds the peer's limit of 79 bytes")
+	require.Equal(t, outputLen, output.Len()) // nothing was written
+	require.NoError(t, encoder.Close())
+	require.Equal(t, []HeaderField{hf1}, decodeAll(t, NewDecoder().Decode(output.Bytes())))
+
+	// the size is reset for the next field section
+	output.Reset()
+	require.NoError(t, encoder.WriteField(hf2))
+	require.NoError(t, encoder.Close())
+	require.Equal(t, []HeaderField{hf2}, decodeAll(t, NewDecoder().Decode(output.Bytes())))
+}
+
+var benchmarkHeaderFields = []HeaderField{
+	{Name: ":method", Value: "GET"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":authority", Value: "quic-go.net"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: "user-agent", Value: "quic-go HTTP/3"},
+	{Name: "accept", Value: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+	{Name: "accept-language", Value: "en-US,en;q=0.5"},
+	{Name: "x-request-id", Value: "f3a9b2c1-5d4e-4f6a-8b7c-9d0e1f2a3b4c"},
+}
+
+func TestEncoderAppendFieldSection(t *testing.T) {
+	var output bytes.Buffer
+	encoder := NewEncoder(&output)
+	for _, hf := range benchmarkHeaderFields {
+		require.NoError(t, encoder.WriteField(hf))
+	}
+	require.NoError(t, encoder.Close())
+
+	dst := []byte("foobar")
+	dst, err := encoder.AppendFieldSection(dst, benchmarkHeaderFields)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(dst[:6]))
+	require.Equal(t, output.Bytes(), dst[6:])
+	require.Equal(t, benchmarkHeaderFields, decodeAll(t, NewDecoder().Decode(dst[6:])))
+
+	// empty field section
+	dst, err = encoder.AppendFieldSection(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0}, dst)
+}
+
+func TestEncoderAppendFieldSectionDynamicTable(t *testing.T) {
+	var encoderStream, output bytes.Buffer
+	encoder := NewEncoder(&output, WithEncoderStream(&encoderStream, 4096))
+	encoder.SetStreamID(4)
+	decoder := NewDecoder(WithMaxTableCapacity(4096))
+
+	dst, err := encoder.AppendFieldSection(nil, benchmarkHeaderFields)
+	require.NoError(t, err)
+	require.Empty(t, output.Bytes()) // nothing is written to the underlying Writer
+	require.NotEmpty(t, encoderStream.Bytes())
+	require.NoError(t, decoder.HandleEncoderStream(encoderStream.Bytes()))
+	require.Equal(t, benchmarkHeaderFields, decodeAll(t, decoder.Decode(dst)))
+	firstLen := len(dst)
+
+	require.NoError(t, encoder.table.onInsertCountIncrement(encoder.table.insertCount()))
+	dst, err = encoder.AppendFieldSection(dst[:0], benchmarkHeaderFields)
+	require.NoError(t, err)
+	_, requiredInsertCount, _ := readPrefix(t, dst)
+	require.NotZero(t, requiredInsertCount)
+	require.Equal(t, benchmarkHeaderFields, decodeAll(t, decoder.DecodeStream(4, dst)))
+	require.Less(t, len(dst), firstLen)
+	// the field section needs to be acknowledged
+	require.NoError(t, encoder.table.onSectionAcknowledgment(4))
+}
+
+func TestEncoderAppendFieldSectionErrors(t *testing.T) {
+	t.Run("field section too large", func(t *testing.T) {
+		encoder := NewEncoder(io.Discard, WithPeerMaxFieldSectionSize(100))
+		dst := []byte("foobar")
+		b, err := encoder.AppendFieldSection(dst, benchmarkHeaderFields)
+		require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+		require.Equal(t, dst, b)
+		// the next field section can be encoded
+		b, err = encoder.AppendFieldSection(nil, benchmarkHeaderFields[:1])
+		require.NoError(t, err)
+		require.Equal(t, benchmarkHeaderFields[:1], decodeAll(t, NewDecoder().Decode(b)))
+	})
+
+	t.Run("encoder stream error", func(t *testing.T) {
+		encoderStream := &errWriter{fail: true}
+		encoder := NewEncoder(io.Discard, WithEncoderStream(encoderStream, 4096))
+		b, err := encoder.AppendFieldSection(nil, benchmarkHeaderFields)
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		require.Nil(t, b)
+		encoderStream.fail = false
+		b, err = encoder.AppendFieldSection(nil, benchmarkHeaderFields)
+		require.NoError(t, err)
+		require.Equal(t, benchmarkHeaderFields, decodeAll(t, NewDecoder(WithMaxTableCapacity(4096)).Decode(b)))
+	})
+}
+
+func BenchmarkEncoderAppendFieldSection(b *testing.B) {
+	b.Run("static table", func(b *testing.B) {
+		benchmarkEncoderAppendFieldSection(b, NewEncoder(io.Discard), nil)
+	})
+
+	b.Run("dynamic table", func(b *testing.B) {
+		encoder := NewEncoder(io.Discard, WithEncoderStream(io.Discard, 4096))
+		// insert all header fields, and acknowledge the insertions
+		if _, err := encoder.AppendFieldSection(nil, benchmarkHeaderFields); err != nil {
+			b.Fatal(err)
+		}
+		if err := encoder.HandleDecoderStream(wire.AppendInsertCountIncrement(nil, encoder.table.insertCount())); err != nil {
+			b.Fatal(err)
+		}
+		benchmarkEncoderAppendFieldSection(b, encoder, wire.AppendSectionAcknowledgment(nil, 0))
+	})
+}
+
+func benchmarkEncoderAppendFieldSection(b *testing.B, encoder *Encoder, ack []byte) {
+	b.ReportAllocs()
+
+	buf := make([]byte, 0, 1024)
+	for b.Loop() {
+		var err error
+		buf, err = encoder.AppendFieldSection(buf[:0], benchmarkHeaderFields)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if ack != nil {
+			if err := encoder.HandleDecoderStream(ack); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.SetBytes(int64(len(buf)))
+}
+
+func TestEncoderHuffmanPolicy(t *testing.T) {
+	shorter := func(s string) bool { return hpack.HuffmanEncodeLength(s) < uint64(len(s)) }
+	for _, value := range []string{
+		"lorem ipsum", // Huffman encoding saves bytes
+		"~|{}^",       // Huffman encoding is longer
+		"XJQZKVWXJQZ", // Huffman encoding has the same length
+		"",
+	} {
+		for _, tc := range []struct {
+			name      string
+			policy    HuffmanPolicy
+			isHuffman func(string) bool
+		}{
+			{name: "if shorter", policy: HuffmanIfShorter, isHuffman: shorter},
+			{name: "always", policy: HuffmanAlways, isHuffman: func(string) bool { return true }},
+			{name: "never", policy: HuffmanNever, isHuffman: func(string) bool { return false }},
+		} {
+			t.Run(fmt.Sprintf("%s, %q", tc.name, value), func(t *testing.T) {
+				hf := HeaderField{Name: "x-value", Value: value}
+				b, err := NewEncoder(io.Discard, WithHuffmanPolicy(tc.policy)).AppendFieldSection(nil, []HeaderField{hf})
+				require.NoError(t, err)
+				data, _, _ := readPrefix(t, b)
+				// literal field line with literal name
+				var name, val stringLiteral
+				data, err = name.read(3, data)
+				require.NoError(t, err)
+				require.Equal(t, tc.isHuffman(hf.Name), name.huffman)
+				data, err = val.read(7, data)
+				require.NoError(t, err)
+				require.Empty(t, data)
+				require.Equal(t, tc.isHuffman(value), val.huffman)
+				if val.huffman {
+					require.Len(t, val.data, int(hpack.HuffmanEncodeLength(value)))
+				} else {
+					require.Equal(t, value, string(val.data))
+				}
+				require.Equal(t, []HeaderField{hf}, decodeAll(t, NewDecoder().Decode(b)))
+			})
+		}
+	}
+	require.True(t, shorter("lorem ipsum"))
+	require.False(t, shorter("XJQZKVWXJQZ"))
+	require.False(t, shorter("~|{}^"))
+}
+
+func TestEncoderHuffmanPolicyEncoderStream(t *testing.T) {
+	hf := HeaderField{Name: "x-value", Value: "~|{}^"}
+	var encoderStream bytes.Buffer
+	encoder := NewEncoder(io.Discard, WithEncoderStream(&encoderStream, 4096), WithHuffmanPolicy(HuffmanNever))
+	_, err := encoder.AppendFieldSection(nil, []HeaderField{hf})
+	require.NoError(t, err)
+
+	var expected []byte
+	expected = wire.AppendSetDynamicTableCapacity(expected, 4096)
+	expected = wire.AppendInsertWithLiteralName(expected, hf.Name, false, hf.Value, false)
+	require.Equal(t, expected, encoderStream.Bytes())
+}
@@ -0,0 +1,72 @@
+package qpack
+
+import (
+	"fmt"
+
+	"github.com/quic-go/qpack/wire"
+)
+
+// An ErrorCode is a QPACK error code, see Section 6 of RFC 9204.
+// QPACK errors are HTTP/3 connection errors.
+type ErrorCode uint64
+
+const (
+	// ErrCodeDecompressionFailed is used when the decoder fails to interpret
+	// an encoded field section and is not able to continue decoding that field section.
+	ErrCodeDecompressionFailed ErrorCode = 0x200
+	// ErrCodeEncoderStreamError is used when the decoder fails to interpret
+	// an encoder instruction received on the encoder stream.
+	ErrCodeEncoderStreamError ErrorCode = 0x201
+	// ErrCodeDecoderStreamError is used when the encoder fails to interpret
+	// a decoder instruction received on the decoder stream.
+	ErrCodeDecoderStreamError ErrorCode = 0x202
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrCodeDecompressionFailed:
+		return "QPACK_DECOMPRESSION_FAILED"
+	case ErrCodeEncoderStreamError:
+		return "QPACK_ENCODER_STREAM_ERROR"
+	case ErrCodeDecoderStreamError:
+		return "QPACK_DECODER_STREAM_ERROR"
+	default:
+		return fmt.Sprintf("unknown error code: %#x", uint64(c))
+	}
+}
+
+// An Error is a QPACK error.
+// The HTTP/3 connection should be closed using the error code.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// A DecodingError describes where decoding a field section failed.
+// It is wrapped in an Error with the code ErrCodeDecompressionFailed.
+type DecodingError struct {
+	// Offset is the byte offset of the field line (or the Encoded Field Section Prefix)
+	// from the beginning of the field section.
+	Offset int
+	// FieldLine is the index of the field line in the field section, starting at 0.
+	// It is -1 if decoding the Encoded Field Section Prefix failed.
+	FieldLine int
+	// Type is the representation of the field line. It is not set for the Encoded Field Section Prefix.
+	Type wire.FieldLineType
+	Err  error
+}
+
+func (e *DecodingError) Error() string {
+	if e.FieldLine < 0 {
+		return fmt.Sprintf("Encoded Field Section Prefix: %s", e.Err)
+	}
+	return fmt.Sprintf("field line %d (%s) at offset %d: %s", e.FieldLine, e.Type, e.Offset, e.Err)
+}
+
+func (e *DecodingError) Unwrap() error { return e.Err }
@@ -0,0 +1,25 @@
+package qpack
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCodeString(t *testing.T) {
+	require.Equal(t, "QPACK_DECOMPRESSION_FAILED", ErrCodeDecompressionFailed.String())
+	require.Equal(t, "QPACK_ENCODER_STREAM_ERROR", ErrCodeEncoderStreamError.String())
+	require.Equal(t, "QPACK_DECODER_STREAM_ERROR", ErrCodeDecoderStreamError.String())
+	require.Equal(t, "unknown error code: 0x1337", ErrorCode(0x1337).String())
+}
+
+func TestError(t *testing.T) {
+	var err error = &Error{Code: ErrCodeDecoderStreamError, Err: io.ErrUnexpectedEOF}
+	require.EqualError(t, err, "QPACK_DECODER_STREAM_ERROR: unexpected EOF")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	var qerr *Error
+	require.True(t, errors.As(err, &qerr))
+	require.Equal(t, ErrCodeDecoderStreamError, qerr.Code)
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 
 	"github.com/quic-go/qpack"
+	"github.com/quic-go/qpack/qif"
 )
 
 func main() {
@@ -19,20 +18,15 @@ func main() {
 
 	dec := qpack.NewDecoder()
 	for {
-		in, err := decodeInput(file)
+		rec, err := qif.ReadRecord(file)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			log.Fatalf("failed to decode input: %v", err)
+			log.Fatalf("failed to read record: %v", err)
 		}
-		fmt.Printf("\nRequest on stream %d:\n", in.streamID)
-		decode := dec.Decode(in.data)
-		for {
-			hf, err := decode()
-			if err == io.EOF {
-				break
-			}
+		fmt.Printf("\nRequest on stream %d:\n", rec.StreamID)
+		for hf, err := range dec.Fields(rec.Data) {
 			if err != nil {
 				log.Fatalf("failed to decode header field: %v", err)
 			}
@@ -40,31 +34,3 @@ func main() {
 		}
 	}
 }
-
-type input struct {
-	streamID uint64
-	data     []byte
-}
-
-func decodeInput(r io.Reader) (*input, error) {
-	prefix := make([]byte, 12)
-	if _, err := io.ReadFull(r, prefix); err != nil {
-		if err == io.EOF {
-			return nil, io.EOF
-		}
-		return nil, fmt.Errorf("insufficient data for prefix: %w", err)
-	}
-	streamID := binary.BigEndian.Uint64(prefix[:8])
-	length := binary.BigEndian.Uint32(prefix[8:12])
-	if length > 1<<15 {
-		return nil, errors.New("input too long")
-	}
-	data := make([]byte, int(length))
-	if _, err := io.ReadFull(r, data); err != nil {
-		return nil, errors.New("incomplete data")
-	}
-	return &input{
-		streamID: streamID,
-		data:     data,
-	}, nil
-}
@@ -0,0 +1,71 @@
+package qpack
+
+import "github.com/quic-go/qpack/wire"
+
+// FieldLineInfo describes how a header field was represented in the field section,
+// see Section 4.5 of RFC 9204.
+// It can be used to analyze how well a peer's encoder compresses header fields.
+type FieldLineInfo struct {
+	// Type is the field line representation.
+	Type wire.FieldLineType
+	// Static is set if the field line references the static table.
+	Static bool
+	// Index is the static table index, or the absolute index of the dynamic table entry.
+	// It is only set if the field line references a table entry, i.e. unless Type is
+	// wire.LiteralFieldLineWithLiteralName.
+	Index uint64
+	// NameHuffman and ValueHuffman are set if the name or the value were Huffman-encoded.
+	// They are only set for string literals.
+	NameHuffman, ValueHuffman bool
+	// NeverIndex is the N bit, see HeaderField.Sensitive.
+	NeverIndex bool
+	// EncodedLen is the number of bytes used to encode the field line.
+	EncodedLen int
+}
+
+func (fl *fieldLine) info() FieldLineInfo {
+	return FieldLineInfo{
+		Type:         fl.typ,
+		Static:       fl.static,
+		Index:        fl.index,
+		NameHuffman:  fl.name.huffman,
+		ValueHuffman: fl.value.huffman,
+		NeverIndex:   fl.sensitive,
+		EncodedLen:   fl.encodedLen,
+	}
+}
+
+// DecodeInfoFunc is like a DecodeFunc, but it also returns a FieldLineInfo for every header field.
+// It returns io.EOF when all header fields have been decoded.
+type DecodeInfoFunc func() (HeaderField, FieldLineInfo, error)
+
+// DecodeWithInfo is like Decode, but it also returns a FieldLineInfo for every header field.
+func (d *Decoder) DecodeWithInfo(p []byte) DecodeInfoFunc {
+	return d.decodeWithInfo(p, 0, false)
+}
+
+// DecodeStreamWithInfo is like DecodeStream, but it also returns a FieldLineInfo for every header field.
+func (d *Decoder) DecodeStreamWithInfo(streamID uint64, p []byte) DecodeInfoFunc {
+	return d.decodeWithInfo(p, streamID, true)
+}
+
+func (d *Decoder) decodeWithInfo(p []byte, streamID uint64, isStream bool) DecodeInfoFunc {
+	s := &sectionDecoder{
+		d:            d,
+		p:            p,
+		streamID:     streamID,
+		isStream:     isStream,
+		fieldSection: fieldSection{remaining: d.maxFieldSectionSize},
+	}
+	return func() (HeaderField, FieldLineInfo, error) {
+		var fl fieldLine
+		if err := s.next(&fl); err != nil {
+			return HeaderField{}, FieldLineInfo{}, err
+		}
+		hf, err := s.headerField(&fl)
+		if err != nil {
+			return HeaderField{}, FieldLineInfo{}, err
+		}
+		return hf, fl.info(), nil
+	}
+}
@@ -0,0 +1,112 @@
+package qpack
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/quic-go/qpack/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecoderDecodeWithInfo(t *testing.T) {
+	var decoderStream bytes.Buffer
+	dec := NewDecoder(WithMaxTableCapacity(200), WithDecoderStream(&decoderStream))
+	var encoderStream []byte
+	encoderStream = wire.AppendSetDynamicTableCapacity(encoderStream, 200)
+	encoderStream = wire.AppendInsertWithLiteralName(encoderStream, "foo", false, "bar", false)
+	encoderStream = wire.AppendInsertWithNameReference(encoderStream, true, 6, "sun", false) // date
+	require.NoError(t, dec.HandleEncoderStream(encoderStream))
+	decoderStream.Reset()
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 2, Base = 1
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 3, Sign: true})
+	type fieldLine struct {
+		data []byte
+		hf   HeaderField
+		info FieldLineInfo
+	}
+	fieldLines := []fieldLine{
+		{
+			data: wire.AppendIndexedFieldLine(nil, true, 17),
+			hf:   HeaderField{Name: ":method", Value: "GET"},
+			info: FieldLineInfo{Type: wire.IndexedFieldLine, Static: true, Index: 17},
+		},
+		{
+			data: wire.AppendIndexedFieldLine(nil, false, 0),
+			hf:   HeaderField{Name: "foo", Value: "bar"},
+			info: FieldLineInfo{Type: wire.IndexedFieldLine, Index: 0},
+		},
+		{
+			data: wire.AppendIndexedFieldLinePostBase(nil, 0),
+			hf:   HeaderField{Name: "date", Value: "sun"},
+			info: FieldLineInfo{Type: wire.IndexedFieldLinePostBase, Index: 1},
+		},
+		{
+			data: wire.AppendLiteralFieldLineWithNameReference(nil, false, true, 1, "/index.html", true),
+			hf:   HeaderField{Name: ":path", Value: "/index.html"},
+			info: FieldLineInfo{Type: wire.LiteralFieldLineWithNameReference, Static: true, Index: 1, ValueHuffman: true},
+		},
+		{
+			data: wire.AppendLiteralFieldLineWithNameReference(nil, true, false, 0, "baz", false),
+			hf:   HeaderField{Name: "foo", Value: "baz", Sensitive: true},
+			info: FieldLineInfo{Type: wire.LiteralFieldLineWithNameReference, Index: 0, NeverIndex: true},
+		},
+		{
+			data: wire.AppendLiteralFieldLineWithPostBaseNameReference(nil, false, 0, "mon", false),
+			hf:   HeaderField{Name: "date", Value: "mon"},
+			info: FieldLineInfo{Type: wire.LiteralFieldLineWithPostBaseNameReference, Index: 1},
+		},
+		{
+			data: wire.AppendLiteralFieldLineWithLiteralName(nil, true, "lorem", true, "ipsum", false),
+			hf:   HeaderField{Name: "lorem", Value: "ipsum", Sensitive: true},
+			info: FieldLineInfo{Type: wire.LiteralFieldLineWithLiteralName, NameHuffman: true, NeverIndex: true},
+		},
+	}
+	for i, fl := range fieldLines {
+		data = append(data, fl.data...)
+		fieldLines[i].info.EncodedLen = len(fl.data)
+	}
+
+	decode := dec.DecodeStreamWithInfo(4, data)
+	for _, fl := range fieldLines {
+		hf, info, err := decode()
+		require.NoError(t, err)
+		require.Equal(t, fl.hf, hf)
+		require.Equal(t, fl.info, info)
+	}
+	_, _, err := decode()
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, wire.AppendSectionAcknowledgment(nil, 4), decoderStream.Bytes())
+
+	// the header fields are the same as the ones returned by DecodeStream
+	decodeInfo := dec.DecodeStreamWithInfo(8, data)
+	for hf, err := range dec.DecodeStream(12, data).All() {
+		require.NoError(t, err)
+		hf2, _, err := decodeInfo()
+		require.NoError(t, err)
+		require.Equal(t, hf, hf2)
+	}
+
+	// without a stream ID, the header block can't be acknowledged
+	_, _, err = dec.DecodeWithInfo(data)()
+	require.ErrorIs(t, err, ErrStreamRequired)
+}
+
+func TestDecoderDecodeWithInfoErrors(t *testing.T) {
+	t.Run("invalid field line", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{})
+		data = wire.AppendIndexedFieldLine(data, true, 1000)
+		_, _, err := NewDecoder().DecodeWithInfo(data)()
+		var qerr *Error
+		require.ErrorAs(t, err, &qerr)
+		require.Equal(t, &DecodingError{Offset: 2, Type: wire.IndexedFieldLine, Err: invalidIndexError(1000)}, qerr.Err)
+	})
+
+	t.Run("field section too large", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{})
+		data = wire.AppendLiteralFieldLineWithLiteralName(data, false, "foo", false, "bar", false)
+		_, _, err := NewDecoder(WithMaxFieldSectionSize(32 + 5)).DecodeWithInfo(data)()
+		require.Equal(t, ErrFieldSectionTooLarge, err)
+	})
+}
@@ -62,6 +62,18 @@ func FuzzDecode(f *testing.F) {
 
 	f.Fuzz(func(t *testing.T, data []byte) {
 		decoder := NewDecoder()
+
+		// DecodeBytes must return the same header fields as Decode
+		var bytesFields []HeaderField
+		bytesErr := decoder.DecodeBytes(data, func(name, value []byte, flags FieldFlags) error {
+			bytesFields = append(bytesFields, HeaderField{
+				Name:      string(name),
+				Value:     string(value),
+				Sensitive: flags&FieldSensitive > 0,
+			})
+			return nil
+		})
+
 		decode := decoder.Decode(data)
 		var fields []HeaderField
 		for {
@@ -71,10 +83,13 @@ func FuzzDecode(f *testing.F) {
 			}
 			if err != nil {
 				_ = err.Error()
+				require.Error(t, bytesErr)
 				return
 			}
 			fields = append(fields, hf)
 		}
+		require.NoError(t, bytesErr)
+		require.Equal(t, fields, bytesFields)
 		if len(fields) == 0 {
 			return
 		}
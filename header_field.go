@@ -5,6 +5,13 @@ package qpack
 type HeaderField struct {
 	Name  string
 	Value string
+
+	// Sensitive means that this header field should never be indexed,
+	// see Section 7.1.3 of RFC 9204.
+	// The encoder always uses a literal representation with the N-bit set for sensitive header fields,
+	// and the decoder sets it when it decodes such a representation.
+	// Intermediaries must preserve it when re-encoding the header field.
+	Sensitive bool
 }
 
 // IsPseudo reports whether the header field is an HTTP3 pseudo header.
@@ -14,3 +21,20 @@ type HeaderField struct {
 func (hf HeaderField) IsPseudo() bool {
 	return len(hf.Name) != 0 && hf.Name[0] == ':'
 }
+
+// DefaultSensitivityPolicy reports whether a header field carries credentials,
+// and should therefore never be indexed.
+// This applies to the authorization and proxy-authorization header fields,
+// as well as to short cookies, which are vulnerable to brute-force attacks
+// (see Section 7.1.3 of RFC 7541).
+// It can be used with WithSensitivityPolicy.
+func DefaultSensitivityPolicy(hf HeaderField) bool {
+	switch hf.Name {
+	case "authorization", "proxy-authorization":
+		return true
+	case "cookie", "set-cookie":
+		return len(hf.Value) < 25
+	default:
+		return false
+	}
+}
@@ -1,6 +1,7 @@
 package qpack
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -18,3 +19,11 @@ func TestHeaderFieldIsPseudo(t *testing.T) {
 		require.False(t, (HeaderField{Name: "foobar"}).IsPseudo())
 	})
 }
+
+func TestDefaultSensitivityPolicy(t *testing.T) {
+	require.True(t, DefaultSensitivityPolicy(HeaderField{Name: "authorization", Value: "Basic Zm9vOmJhcg=="}))
+	require.True(t, DefaultSensitivityPolicy(HeaderField{Name: "proxy-authorization", Value: "Basic Zm9vOmJhcg=="}))
+	require.True(t, DefaultSensitivityPolicy(HeaderField{Name: "cookie", Value: "session=12345"}))
+	require.False(t, DefaultSensitivityPolicy(HeaderField{Name: "cookie", Value: "preferences=" + strings.Repeat("a", 100)}))
+	require.False(t, DefaultSensitivityPolicy(HeaderField{Name: "user-agent", Value: "quic-go"}))
+}
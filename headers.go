@@ -0,0 +1,145 @@
+package qpack
+
+import (
+	"io"
+	"strconv"
+)
+
+// RequestHeaders is the header section of an HTTP/3 request.
+// The pseudo-header fields are stored separately from the regular header fields.
+type RequestHeaders struct {
+	Method    string
+	Scheme    string
+	Authority string
+	Path      string
+	// Protocol is the :protocol pseudo-header field of an extended CONNECT request, see RFC 9220.
+	Protocol string
+
+	// Fields are the regular header fields, in the order they appear in the header section.
+	// They must not contain pseudo-header fields.
+	Fields []HeaderField
+}
+
+// Decode decodes the header section of a request from the header fields returned by decode,
+// e.g. by Decoder.DecodeStream or Decoder.DecodeContext.
+// The header section is validated, see Validator.
+// Any *MalformedError must be treated as a stream error of type H3_MESSAGE_ERROR.
+//
+// Decode reuses the memory of h.Fields.
+// If decode returns ErrBlocked, decoding is aborted. Use Decoder.DecodeContext to wait instead.
+func (h *RequestHeaders) Decode(decode DecodeFunc) error {
+	*h = RequestHeaders{Fields: h.Fields[:0]}
+	decode = decode.Validate(RequestHeaderSection)
+	for {
+		hf, err := decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hf.Name {
+		case ":method":
+			h.Method = hf.Value
+		case ":scheme":
+			h.Scheme = hf.Value
+		case ":authority":
+			h.Authority = hf.Value
+		case ":path":
+			h.Path = hf.Value
+		case ":protocol":
+			h.Protocol = hf.Value
+		default:
+			h.Fields = append(h.Fields, hf)
+		}
+	}
+}
+
+// Encode encodes the header section of a request, and appends it to dst, see Encoder.AppendFieldSection.
+// The pseudo-header fields are encoded before the regular header fields.
+// Empty pseudo-header fields are omitted.
+// If the resulting header section is malformed, a *MalformedError is returned, and dst is returned unmodified.
+func (h *RequestHeaders) Encode(e *Encoder, dst []byte) ([]byte, error) {
+	fields := make([]HeaderField, 0, 5+len(h.Fields))
+	for _, hf := range [...]HeaderField{
+		{Name: ":method", Value: h.Method},
+		{Name: ":scheme", Value: h.Scheme},
+		{Name: ":authority", Value: h.Authority},
+		{Name: ":path", Value: h.Path},
+		{Name: ":protocol", Value: h.Protocol},
+	} {
+		if hf.Value != "" {
+			fields = append(fields, hf)
+		}
+	}
+	fields = append(fields, h.Fields...)
+	if err := validateEncodedFields(RequestHeaderSection, fields, len(fields)-len(h.Fields)); err != nil {
+		return dst, err
+	}
+	return e.AppendFieldSection(dst, fields)
+}
+
+// ResponseHeaders is the header section of an HTTP/3 response.
+type ResponseHeaders struct {
+	Status int // the :status pseudo-header field
+
+	// Fields are the regular header fields, in the order they appear in the header section.
+	// They must not contain pseudo-header fields.
+	Fields []HeaderField
+}
+
+// Decode decodes the header section of a response from the header fields returned by decode,
+// e.g. by Decoder.DecodeStream or Decoder.DecodeContext.
+// The header section is validated, see Validator.
+// Any *MalformedError must be treated as a stream error of type H3_MESSAGE_ERROR.
+//
+// Decode reuses the memory of h.Fields.
+// If decode returns ErrBlocked, decoding is aborted. Use Decoder.DecodeContext to wait instead.
+func (h *ResponseHeaders) Decode(decode DecodeFunc) error {
+	*h = ResponseHeaders{Fields: h.Fields[:0]}
+	decode = decode.Validate(ResponseHeaderSection)
+	for {
+		hf, err := decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hf.Name == ":status" {
+			// the Validator made sure that the status code consists of 3 digits
+			h.Status, _ = strconv.Atoi(hf.Value)
+			continue
+		}
+		h.Fields = append(h.Fields, hf)
+	}
+}
+
+// Encode encodes the header section of a response, and appends it to dst, see Encoder.AppendFieldSection.
+// The :status pseudo-header field is encoded before the regular header fields.
+// If the resulting header section is malformed, a *MalformedError is returned, and dst is returned unmodified.
+func (h *ResponseHeaders) Encode(e *Encoder, dst []byte) ([]byte, error) {
+	fields := make([]HeaderField, 0, 1+len(h.Fields))
+	fields = append(fields, HeaderField{Name: ":status", Value: strconv.Itoa(h.Status)})
+	fields = append(fields, h.Fields...)
+	if err := validateEncodedFields(ResponseHeaderSection, fields, 1); err != nil {
+		return dst, err
+	}
+	return e.AppendFieldSection(dst, fields)
+}
+
+// validateEncodedFields validates a header section before it is encoded.
+// The first numPseudo fields are the pseudo-header fields,
+// all other fields are regular header fields.
+func validateEncodedFields(kind FieldSectionKind, fields []HeaderField, numPseudo int) error {
+	v := NewValidator(kind)
+	for i, hf := range fields {
+		if i >= numPseudo && hf.IsPseudo() {
+			return &MalformedError{Field: hf.Name, Rule: "pseudo-header field in Fields"}
+		}
+		if err := v.Field(hf); err != nil {
+			return err
+		}
+	}
+	return v.Done()
+}
@@ -0,0 +1,212 @@
+package qpack
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		headers  RequestHeaders
+		expected []HeaderField
+	}{
+		{
+			name: "GET request",
+			headers: RequestHeaders{
+				Method:    "GET",
+				Scheme:    "https",
+				Authority: "quic-go.net",
+				Path:      "/",
+				Fields:    []HeaderField{{Name: "user-agent", Value: "quic-go"}},
+			},
+			expected: validRequest(HeaderField{Name: "user-agent", Value: "quic-go"}),
+		},
+		{
+			name:    "CONNECT request",
+			headers: RequestHeaders{Method: "CONNECT", Authority: "quic-go.net:443"},
+			expected: []HeaderField{
+				{Name: ":method", Value: "CONNECT"},
+				{Name: ":authority", Value: "quic-go.net:443"},
+			},
+		},
+		{
+			name: "extended CONNECT request",
+			headers: RequestHeaders{
+				Method:    "CONNECT",
+				Scheme:    "https",
+				Authority: "quic-go.net",
+				Path:      "/wt",
+				Protocol:  "webtransport",
+			},
+			expected: []HeaderField{
+				{Name: ":method", Value: "CONNECT"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":authority", Value: "quic-go.net"},
+				{Name: ":path", Value: "/wt"},
+				{Name: ":protocol", Value: "webtransport"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.headers.Encode(NewEncoder(nil), nil)
+			require.NoError(t, err)
+			hfs, err := NewDecoder().DecodeAll(b)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, hfs)
+
+			var h RequestHeaders
+			require.NoError(t, h.Decode(NewDecoder().Decode(b)))
+			require.Equal(t, tc.headers, h)
+		})
+	}
+}
+
+func TestRequestHeadersEncodeMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		headers     RequestHeaders
+		field, rule string
+	}{
+		{
+			name:    "missing :method",
+			headers: RequestHeaders{Scheme: "https", Authority: "quic-go.net", Path: "/"},
+			field:   ":method",
+			rule:    "missing pseudo-header field",
+		},
+		{
+			name:    "missing :path",
+			headers: RequestHeaders{Method: "GET", Scheme: "https", Authority: "quic-go.net"},
+			field:   ":path",
+			rule:    "missing pseudo-header field",
+		},
+		{
+			name:    ":protocol without CONNECT",
+			headers: RequestHeaders{Method: "GET", Scheme: "https", Authority: "quic-go.net", Path: "/", Protocol: "websocket"},
+			field:   ":protocol",
+			rule:    "pseudo-header field not allowed in non-CONNECT request",
+		},
+		{
+			name: "pseudo-header field in Fields",
+			headers: RequestHeaders{
+				Method: "GET",
+				Scheme: "https",
+				Path:   "/",
+				Fields: []HeaderField{{Name: "host", Value: "quic-go.net"}, {Name: ":authority", Value: "quic-go.net"}},
+			},
+			field: ":authority",
+			rule:  "pseudo-header field in Fields",
+		},
+		{
+			name: "connection-specific field",
+			headers: RequestHeaders{
+				Method:    "GET",
+				Scheme:    "https",
+				Authority: "quic-go.net",
+				Path:      "/",
+				Fields:    []HeaderField{{Name: "connection", Value: "close"}},
+			},
+			field: "connection",
+			rule:  "connection-specific field",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := []byte("foobar")
+			b, err := tc.headers.Encode(NewEncoder(nil), dst)
+			var merr *MalformedError
+			require.ErrorAs(t, err, &merr)
+			require.Equal(t, tc.field, merr.Field)
+			require.Equal(t, tc.rule, merr.Rule)
+			require.Equal(t, dst, b)
+		})
+	}
+}
+
+func TestRequestHeadersDecode(t *testing.T) {
+	t.Run("malformed", func(t *testing.T) {
+		b, err := NewEncoder(nil).AppendFieldSection(nil, []HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":path", Value: "/"},
+		})
+		require.NoError(t, err)
+		var h RequestHeaders
+		var merr *MalformedError
+		require.ErrorAs(t, h.Decode(NewDecoder().Decode(b)), &merr)
+		require.Equal(t, ":scheme", merr.Field)
+	})
+
+	t.Run("reusing the fields", func(t *testing.T) {
+		b, err := NewEncoder(nil).AppendFieldSection(nil, validRequest(HeaderField{Name: "foo", Value: "bar"}))
+		require.NoError(t, err)
+		fields := make([]HeaderField, 0, 4)
+		h := RequestHeaders{Method: "POST", Fields: fields}
+		require.NoError(t, h.Decode(NewDecoder().Decode(b)))
+		require.Equal(t, "GET", h.Method)
+		require.Equal(t, []HeaderField{{Name: "foo", Value: "bar"}}, h.Fields)
+		require.Same(t, &fields[:1][0], &h.Fields[0])
+	})
+
+	t.Run("dynamic table", func(t *testing.T) {
+		encoderStream := &bytes.Buffer{}
+		enc := NewEncoder(nil, WithEncoderStream(encoderStream, 1000))
+		enc.SetStreamID(4)
+		headers := RequestHeaders{
+			Method:    "GET",
+			Scheme:    "https",
+			Authority: "quic-go.net",
+			Path:      "/",
+			Fields:    []HeaderField{{Name: "foo", Value: "bar"}},
+		}
+		b, err := headers.Encode(enc, nil)
+		require.NoError(t, err)
+		dec := NewDecoder(WithMaxTableCapacity(1000))
+		require.NoError(t, dec.HandleEncoderStream(encoderStream.Bytes()))
+		var h RequestHeaders
+		require.NoError(t, h.Decode(dec.DecodeStream(4, b)))
+		require.Equal(t, headers, h)
+	})
+}
+
+func TestResponseHeaders(t *testing.T) {
+	headers := ResponseHeaders{
+		Status: 404,
+		Fields: []HeaderField{{Name: "content-type", Value: "text/plain"}, {Name: "content-length", Value: "42"}},
+	}
+	b, err := headers.Encode(NewEncoder(nil), nil)
+	require.NoError(t, err)
+	hfs, err := NewDecoder().DecodeAll(b)
+	require.NoError(t, err)
+	require.Equal(t, append([]HeaderField{{Name: ":status", Value: "404"}}, headers.Fields...), hfs)
+
+	var h ResponseHeaders
+	require.NoError(t, h.Decode(NewDecoder().Decode(b)))
+	require.Equal(t, headers, h)
+}
+
+func TestResponseHeadersMalformed(t *testing.T) {
+	t.Run("invalid status code", func(t *testing.T) {
+		_, err := (&ResponseHeaders{Status: 42}).Encode(NewEncoder(nil), nil)
+		var merr *MalformedError
+		require.ErrorAs(t, err, &merr)
+		require.Equal(t, ":status", merr.Field)
+		require.Equal(t, "invalid status code", merr.Rule)
+	})
+
+	t.Run("pseudo-header field in Fields", func(t *testing.T) {
+		_, err := (&ResponseHeaders{Status: 200, Fields: []HeaderField{{Name: ":status", Value: "200"}}}).Encode(NewEncoder(nil), nil)
+		var merr *MalformedError
+		require.ErrorAs(t, err, &merr)
+		require.Equal(t, "pseudo-header field in Fields", merr.Rule)
+	})
+
+	t.Run("missing :status", func(t *testing.T) {
+		b, err := NewEncoder(nil).AppendFieldSection(nil, []HeaderField{{Name: "content-type", Value: "text/plain"}})
+		require.NoError(t, err)
+		var h ResponseHeaders
+		var merr *MalformedError
+		require.ErrorAs(t, h.Decode(NewDecoder().Decode(b)), &merr)
+		require.Equal(t, ":status", merr.Field)
+	})
+}
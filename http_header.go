@@ -0,0 +1,71 @@
+package qpack
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// AppendHTTPHeader converts h to header fields, and appends them to dst.
+// Field names are lowercased, as required by Section 4.2 of RFC 9114,
+// and every value is encoded as a separate field line.
+// The fields are sorted by name, so that the same http.Header always results in the same field section.
+//
+// Cookie header fields are split into individual cookie-pairs ("crumbs"), see Section 4.2.1 of RFC 9114.
+// This allows the encoder to index cookies individually,
+// since usually only few of them change between requests.
+func AppendHTTPHeader(dst []HeaderField, h http.Header) []HeaderField {
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		name := strings.ToLower(key)
+		for _, value := range h[key] {
+			if name == "cookie" {
+				dst = appendCookieCrumbs(dst, value)
+				continue
+			}
+			dst = append(dst, HeaderField{Name: name, Value: value})
+		}
+	}
+	return dst
+}
+
+// appendCookieCrumbs splits a cookie header field at the semicolons, and appends the crumbs to dst.
+func appendCookieCrumbs(dst []HeaderField, cookie string) []HeaderField {
+	for crumb := range strings.SplitSeq(cookie, ";") {
+		crumb = strings.Trim(crumb, " \t")
+		if crumb == "" {
+			continue
+		}
+		dst = append(dst, HeaderField{Name: "cookie", Value: crumb})
+	}
+	return dst
+}
+
+// HTTPHeader converts header fields to an http.Header.
+// Field names are canonicalized using http.CanonicalHeaderKey.
+// Fields that occur multiple times are added as multiple values.
+// Pseudo-header fields are skipped.
+//
+// Multiple cookie header fields are joined into a single value,
+// delimited by "; ", see Section 4.2.1 of RFC 9114.
+func HTTPHeader(fields []HeaderField) http.Header {
+	h := make(http.Header, len(fields))
+	var cookie strings.Builder
+	for _, hf := range fields {
+		if hf.IsPseudo() {
+			continue
+		}
+		if hf.Name == "cookie" {
+			if cookie.Len() > 0 {
+				cookie.WriteString("; ")
+			}
+			cookie.WriteString(hf.Value)
+			continue
+		}
+		h.Add(hf.Name, hf.Value)
+	}
+	if cookie.Len() > 0 {
+		h.Set("Cookie", cookie.String())
+	}
+	return h
+}
@@ -0,0 +1,68 @@
+package qpack
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendHTTPHeader(t *testing.T) {
+	h := http.Header{
+		"User-Agent":      {"quic-go"},
+		"Accept-Encoding": {"gzip", "br"},
+		"Cookie":          {"a=b; c=d;e=f", "g=h"},
+		"x-lowercase":     {"foo"},
+	}
+	dst := []HeaderField{{Name: ":method", Value: "GET"}}
+	require.Equal(t,
+		[]HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: "accept-encoding", Value: "gzip"},
+			{Name: "accept-encoding", Value: "br"},
+			{Name: "cookie", Value: "a=b"},
+			{Name: "cookie", Value: "c=d"},
+			{Name: "cookie", Value: "e=f"},
+			{Name: "cookie", Value: "g=h"},
+			{Name: "user-agent", Value: "quic-go"},
+			{Name: "x-lowercase", Value: "foo"},
+		},
+		AppendHTTPHeader(dst, h),
+	)
+}
+
+func TestAppendHTTPHeaderEmptyCrumbs(t *testing.T) {
+	require.Equal(t,
+		[]HeaderField{{Name: "cookie", Value: "a=b"}, {Name: "cookie", Value: "c=d"}},
+		AppendHTTPHeader(nil, http.Header{"Cookie": {"; a=b;; c=d ;", ""}}),
+	)
+}
+
+func TestHTTPHeader(t *testing.T) {
+	h := HTTPHeader([]HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "cookie", Value: "a=b"},
+		{Name: "content-type", Value: "text/plain"},
+		{Name: "set-cookie", Value: "a=b"},
+		{Name: "cookie", Value: "c=d"},
+		{Name: "set-cookie", Value: "c=d"},
+	})
+	require.Equal(t, http.Header{
+		"Content-Type": {"text/plain"},
+		"Cookie":       {"a=b; c=d"},
+		"Set-Cookie":   {"a=b", "c=d"},
+	}, h)
+}
+
+func TestHTTPHeaderRoundTrip(t *testing.T) {
+	h := http.Header{
+		"Accept":  {"text/html", "application/json"},
+		"Cookie":  {"session=1234; theme=dark; lang=en"},
+		"Referer": {"https://quic-go.net/"},
+	}
+	b, err := NewEncoder(nil).AppendFieldSection(nil, AppendHTTPHeader(nil, h))
+	require.NoError(t, err)
+	hfs, err := NewDecoder().DecodeAll(b)
+	require.NoError(t, err)
+	require.Equal(t, h, HTTPHeader(hfs))
+}
@@ -1,8 +1,7 @@
 package interop
 
 import (
-	"bufio"
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"io"
 	"log"
@@ -10,10 +9,11 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"testing"
 
 	"github.com/quic-go/qpack"
+	"github.com/quic-go/qpack/qif"
+	"github.com/quic-go/qpack/wire"
 
 	"github.com/stretchr/testify/require"
 )
@@ -22,19 +22,23 @@ type request struct {
 	headers []qpack.HeaderField
 }
 
-type qif struct {
+type qifFile struct {
 	requests []request
 }
 
-var qifs map[string]qif
+var qifs map[string]qifFile
 
 func init() {
-	qifs = make(map[string]qif)
+	qifs = make(map[string]qifFile)
 	readQIFs()
 }
 
 func readQIFs() {
 	qifDir := currentDir() + "/qifs/qifs"
+	// The tests are skipped if the submodule hasn't been checked out, see skipIfNoQIFs.
+	if _, err := os.Stat(qifDir); os.IsNotExist(err) {
+		return
+	}
 	if err := filepath.Walk(qifDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -49,54 +53,27 @@ func readQIFs() {
 			return err
 		}
 		defer file.Close()
-		requests := parseRequests(file)
-		qifs[name] = qif{requests: requests}
+		blocks, err := qif.Read(file)
+		if err != nil {
+			return err
+		}
+		requests := make([]request, 0, len(blocks))
+		for _, headers := range blocks {
+			requests = append(requests, request{headers})
+		}
+		qifs[name] = qifFile{requests: requests}
 		return nil
 	}); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func parseRequests(r io.Reader) []request {
-	lr := bufio.NewReader(r)
-	var reqs []request
-	for {
-		headers, done := parseRequest(lr)
-		if done {
-			break
-		}
-		reqs = append(reqs, request{headers})
+// skipIfNoQIFs skips the test if the qifs submodule hasn't been checked out.
+func skipIfNoQIFs(t *testing.T) {
+	t.Helper()
+	if len(qifs) == 0 {
+		t.Skip("QIF files not found, run `git submodule update --init` to check out interop/qifs")
 	}
-	return reqs
-}
-
-func parseRequest(lr *bufio.Reader) (headers []qpack.HeaderField, done bool) {
-	for {
-		line, isPrefix, err := lr.ReadLine()
-		if err == io.EOF {
-			return headers, true
-		}
-		if err != nil {
-			return nil, true
-		}
-		if isPrefix {
-			return nil, true
-		}
-		if len(line) == 0 {
-			break
-		}
-		split := strings.Split(string(line), "\t")
-		if len(split) != 1 && len(split) != 2 {
-			return nil, true
-		}
-		name := split[0]
-		var val string
-		if len(split) == 2 {
-			val = split[1]
-		}
-		headers = append(headers, qpack.HeaderField{Name: name, Value: val})
-	}
-	return headers, false
 }
 
 func currentDir() string {
@@ -111,6 +88,9 @@ func findFiles() []string {
 	var files []string
 	encodedDir := currentDir() + "/qifs/encoded/qpack-06/"
 	filepath.Walk(encodedDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if info.IsDir() {
 			return nil
 		}
@@ -118,74 +98,222 @@ func findFiles() []string {
 		if file == "draft-examples.out" {
 			return nil
 		}
-		split := strings.Split(file, ".")
-		tableSize := split[len(split)-3]
-		if tableSize == "0" {
-			files = append(files, path)
-		}
+		files = append(files, path)
 		return nil
 	})
 	return files
 }
 
-func parseInput(r io.Reader) (uint64, []byte) {
-	prefix := make([]byte, 12)
-	if _, err := io.ReadFull(r, prefix); err != nil {
-		return 0, nil
-	}
-	streamID := binary.BigEndian.Uint64(prefix[:8])
-	length := binary.BigEndian.Uint32(prefix[8:12])
-	if length > 1<<15 {
-		return 0, nil
-	}
-	data := make([]byte, int(length))
-	if _, err := io.ReadFull(r, data); err != nil {
-		return 0, nil
-	}
-	return streamID, data
+// A pendingSection is a field section that might be blocked on the encoder stream.
+type pendingSection struct {
+	streamID       uint64
+	decode         qpack.DecodeFunc
+	headers        []qpack.HeaderField
+	expected       []qpack.HeaderField
+	referenceTable bool // the field section references the dynamic table
 }
 
 func TestInteropDecodingEncodedFiles(t *testing.T) {
+	skipIfNoQIFs(t)
 	filenames := findFiles()
+	require.NotEmpty(t, filenames, "no encoded files found")
 	for _, path := range filenames {
 		fpath, filename := filepath.Split(path)
 		prettyPath := path[len(filepath.Dir(filepath.Dir(filepath.Dir(fpath))))+1:]
 
 		t.Run(fmt.Sprintf("Decoding_%s", prettyPath), func(t *testing.T) {
-			qif, ok := qifs[strings.Split(filename, ".")[0]]
+			params, err := qif.ParseEncodedFileName(filename)
+			require.NoError(t, err)
+			qifFile, ok := qifs[params.QIF]
 			require.True(t, ok)
 
 			file, err := os.Open(path)
 			require.NoError(t, err)
 			defer file.Close()
 
-			var numRequests, numHeaderFields int
-			require.NotEmpty(t, qif.requests)
+			require.NotEmpty(t, qifFile.requests)
 
-			decoder := qpack.NewDecoder()
+			var decoderStream bytes.Buffer
+			decoder := qpack.NewDecoder(
+				qpack.WithMaxTableCapacity(params.TableSize),
+				qpack.WithMaxBlockedStreams(params.MaxBlockedStreams),
+				qpack.WithDecoderStream(&decoderStream),
+			)
 
-			for _, req := range qif.requests {
-				_, data := parseInput(file)
-				require.NotNil(t, data)
-
-				var headers []qpack.HeaderField
-				decode := decoder.Decode(data)
+			var numRequests, numHeaderFields int
+			var blocked []*pendingSection
+			// decodeSection decodes a field section, unless it is blocked.
+			decodeSection := func(s *pendingSection) (done bool) {
 				for {
-					hf, err := decode()
+					hf, err := s.decode()
 					if err == io.EOF {
 						break
 					}
+					if err == qpack.ErrBlocked {
+						return false
+					}
 					require.NoError(t, err)
-					headers = append(headers, hf)
+					hf.Sensitive = false // the QIF format doesn't encode the N-bit
+					s.headers = append(s.headers, hf)
+				}
+				require.Equal(t, s.expected, s.headers)
+				numRequests++
+				numHeaderFields += len(s.headers)
+				if params.ImmediateAck && s.referenceTable {
+					requireSectionAcknowledgment(t, decoderStream.Bytes(), s.streamID)
 				}
+				return true
+			}
 
-				require.Equal(t, req.headers, headers)
+			for {
+				rec, err := qif.ReadRecord(file)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
 
-				numRequests++
-				numHeaderFields += len(headers)
+				if rec.StreamID == qif.EncoderStreamID {
+					require.NoError(t, decoder.HandleEncoderStream(rec.Data))
+					stillBlocked := blocked[:0]
+					for _, s := range blocked {
+						if !decodeSection(s) {
+							stillBlocked = append(stillBlocked, s)
+						}
+					}
+					blocked = stillBlocked
+					continue
+				}
+
+				require.Less(t, numRequests+len(blocked), len(qifFile.requests), "more field sections than requests")
+				prefix, _, err := wire.ParseFieldSectionPrefix(rec.Data)
+				require.NoError(t, err)
+				s := &pendingSection{
+					streamID:       rec.StreamID,
+					decode:         decoder.DecodeStream(rec.StreamID, rec.Data),
+					expected:       qifFile.requests[numRequests+len(blocked)].headers,
+					referenceTable: prefix.EncodedInsertCount > 0,
+				}
+				if !decodeSection(s) {
+					blocked = append(blocked, s)
+					require.LessOrEqual(t, uint64(len(blocked)), params.MaxBlockedStreams)
+				}
 			}
+			require.Empty(t, blocked, "field sections still blocked at the end of the file")
+			require.Equal(t, len(qifFile.requests), numRequests)
 
-			t.Logf("Decoded %d requests containing %d header fields.", len(qif.requests), numHeaderFields)
+			t.Logf("Decoded %d requests containing %d header fields.", numRequests, numHeaderFields)
+		})
+	}
+}
+
+// requireSectionAcknowledgment checks that the decoder acknowledged the field section on the given stream.
+func requireSectionAcknowledgment(t *testing.T, decoderStream []byte, streamID uint64) {
+	t.Helper()
+	for len(decoderStream) > 0 {
+		in, rest, err := wire.ParseDecoderInstruction(decoderStream)
+		require.NoError(t, err)
+		if in.Type == wire.SectionAcknowledgment && in.StreamID == streamID {
+			return
+		}
+		decoderStream = rest
+	}
+	t.Fatalf("field section on stream %d was not acknowledged", streamID)
+}
+
+func TestInteropCompression(t *testing.T) {
+	skipIfNoQIFs(t)
+	policies := []struct {
+		name   string
+		policy qpack.HuffmanPolicy
+	}{
+		{name: "raw", policy: qpack.HuffmanNever},
+		{name: "Huffman", policy: qpack.HuffmanAlways},
+		{name: "Huffman if shorter", policy: qpack.HuffmanIfShorter},
+	}
+	var uncompressed int
+	compressed := make([]int, len(policies))
+	for name, qifFile := range qifs {
+		for _, req := range qifFile.requests {
+			for _, hf := range req.headers {
+				uncompressed += len(hf.Name) + len(hf.Value)
+			}
+		}
+		sizes := make([]int, len(policies))
+		for i, p := range policies {
+			encoder := qpack.NewEncoder(io.Discard, qpack.WithHuffmanPolicy(p.policy))
+			decoder := qpack.NewDecoder()
+			var buf []byte
+			for _, req := range qifFile.requests {
+				var err error
+				buf, err = encoder.AppendFieldSection(buf[:0], req.headers)
+				require.NoError(t, err)
+				sizes[i] += len(buf)
+				headers, err := decoder.DecodeAll(buf)
+				require.NoError(t, err)
+				require.Equal(t, req.headers, headers)
+			}
+			compressed[i] += sizes[i]
+		}
+		require.LessOrEqual(t, sizes[2], sizes[0], name)
+		require.LessOrEqual(t, sizes[2], sizes[1], name)
+	}
+
+	t.Logf("Encoded %d bytes of header fields from %d files:", uncompressed, len(qifs))
+	for i, p := range policies {
+		t.Logf("%s: %d bytes (%.1f%%)", p.name, compressed[i], 100*float64(compressed[i])/float64(uncompressed))
+	}
+}
+
+// TestInteropEncoding encodes all QIF files, writes the output to an encoded file,
+// and decodes the encoded file.
+// The encoded file is read while it is written, and the decoder stream is passed back to the encoder,
+// allowing the encoder to reference dynamic table entries once they have been acknowledged.
+func TestInteropEncoding(t *testing.T) {
+	skipIfNoQIFs(t)
+	for _, capacity := range []uint64{0, 256, 4096} {
+		t.Run(fmt.Sprintf("table capacity %d", capacity), func(t *testing.T) {
+			var numBytes int
+			for name, qifFile := range qifs {
+				var encoded, encoderStream, decoderStream bytes.Buffer
+				encoder := qpack.NewEncoder(nil, qpack.WithEncoderStream(&encoderStream, capacity))
+				decoder := qpack.NewDecoder(qpack.WithMaxTableCapacity(capacity), qpack.WithDecoderStream(&decoderStream))
+
+				for i, req := range qifFile.requests {
+					streamID := uint64(4 * (i + 1))
+					encoder.SetStreamID(streamID)
+					data, err := encoder.AppendFieldSection(nil, req.headers)
+					require.NoError(t, err)
+					if encoderStream.Len() > 0 {
+						require.NoError(t, qif.WriteRecord(&encoded, qif.Record{StreamID: qif.EncoderStreamID, Data: encoderStream.Bytes()}))
+						encoderStream.Reset()
+					}
+					require.NoError(t, qif.WriteRecord(&encoded, qif.Record{StreamID: streamID, Data: data}))
+					numBytes += len(data)
+
+					for {
+						rec, err := qif.ReadRecord(&encoded)
+						if err == io.EOF {
+							break
+						}
+						require.NoError(t, err)
+						if rec.StreamID == qif.EncoderStreamID {
+							require.NoError(t, decoder.HandleEncoderStream(rec.Data))
+							numBytes += len(rec.Data)
+							continue
+						}
+						require.Equal(t, streamID, rec.StreamID)
+						var headers []qpack.HeaderField
+						for hf, err := range decoder.DecodeStream(rec.StreamID, rec.Data).All() {
+							require.NoError(t, err, name)
+							headers = append(headers, hf)
+						}
+						require.Equal(t, req.headers, headers, name)
+					}
+					require.NoError(t, encoder.HandleDecoderStream(decoderStream.Bytes()))
+					decoderStream.Reset()
+				}
+			}
+			t.Logf("Encoded %d files into %d bytes.", len(qifs), numBytes)
 		})
 	}
 }
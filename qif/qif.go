@@ -0,0 +1,171 @@
+// Package qif reads and writes the file formats used by the QPACK interop tests,
+// see https://github.com/qpackers/qifs.
+//
+// A QIF file is a text file containing header blocks.
+// Every header field is written on a separate line, with name and value separated by a tab.
+// Header blocks are separated by empty lines, and lines starting with a # are comments.
+//
+// An encoded file contains the output of a QPACK encoder, as a sequence of records.
+// Every record consists of the stream ID (8 bytes), the length of the data (4 bytes),
+// followed by the data. Records on stream 0 contain encoder stream data,
+// all other records contain a field section.
+package qif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/qpack"
+)
+
+// maxLineLength is the maximum length of a line in a QIF file.
+const maxLineLength = 1 << 20
+
+// Read reads all header blocks from a QIF file.
+// Comments are skipped, as are empty header blocks.
+func Read(r io.Reader) ([][]qpack.HeaderField, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxLineLength)
+	var blocks [][]qpack.HeaderField
+	var block []qpack.HeaderField
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = nil
+			}
+			continue
+		}
+		name, value, _ := strings.Cut(line, "\t")
+		block = append(block, qpack.HeaderField{Name: name, Value: value})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Write writes header blocks to a QIF file.
+// Header fields must not contain tabs or line breaks.
+func Write(w io.Writer, blocks [][]qpack.HeaderField) error {
+	bw := bufio.NewWriter(w)
+	for i, block := range blocks {
+		if i > 0 {
+			bw.WriteByte('\n')
+		}
+		for _, hf := range block {
+			if strings.ContainsAny(hf.Name, "\t\r\n") || strings.ContainsAny(hf.Value, "\r\n") {
+				return fmt.Errorf("qif: header field %q can't be represented", hf.Name)
+			}
+			bw.WriteString(hf.Name)
+			bw.WriteByte('\t')
+			bw.WriteString(hf.Value)
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// EncodedFileName contains the encoder settings that are part of the name of an encoded file.
+// Encoded files are named <qif name>.out.<table size>.<max blocked streams>.<immediate ack>,
+// e.g. fb-req.out.256.100.1.
+type EncodedFileName struct {
+	QIF string // the name of the QIF file, without the extension
+	// TableSize is the dynamic table capacity.
+	TableSize uint64
+	// MaxBlockedStreams is the maximum number of streams that may be blocked on the encoder stream.
+	MaxBlockedStreams uint64
+	// ImmediateAck is set if the encoder assumed that every field section is acknowledged immediately.
+	ImmediateAck bool
+}
+
+// ParseEncodedFileName parses the name of an encoded file.
+func ParseEncodedFileName(name string) (EncodedFileName, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 5 || parts[1] != "out" {
+		return EncodedFileName{}, fmt.Errorf("qif: invalid encoded file name: %q", name)
+	}
+	tableSize, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return EncodedFileName{}, fmt.Errorf("qif: invalid table size in encoded file name: %q", name)
+	}
+	maxBlocked, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return EncodedFileName{}, fmt.Errorf("qif: invalid number of blocked streams in encoded file name: %q", name)
+	}
+	if parts[4] != "0" && parts[4] != "1" {
+		return EncodedFileName{}, fmt.Errorf("qif: invalid immediate ack flag in encoded file name: %q", name)
+	}
+	return EncodedFileName{
+		QIF:               parts[0],
+		TableSize:         tableSize,
+		MaxBlockedStreams: maxBlocked,
+		ImmediateAck:      parts[4] == "1",
+	}, nil
+}
+
+// EncoderStreamID is the stream ID used for encoder stream data in encoded files.
+const EncoderStreamID = 0
+
+// A Record is a record in an encoded file.
+type Record struct {
+	StreamID uint64
+	Data     []byte
+}
+
+// ErrRecordTooLarge is returned when writing a record that's larger than 4 GB.
+var ErrRecordTooLarge = errors.New("qif: record too large")
+
+// ReadRecord reads the next record from an encoded file.
+// It returns io.EOF if there are no more records,
+// and io.ErrUnexpectedEOF if the last record is incomplete.
+func ReadRecord(r io.Reader) (Record, error) {
+	var prefix [12]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Record{}, err
+	}
+	// The buffer grows as the data is read, so that a corrupt length
+	// doesn't cause a large allocation.
+	var data bytes.Buffer
+	if _, err := io.CopyN(&data, r, int64(binary.BigEndian.Uint32(prefix[8:]))); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	return Record{StreamID: binary.BigEndian.Uint64(prefix[:8]), Data: data.Bytes()}, nil
+}
+
+// AppendRecord appends a record to b.
+func AppendRecord(b []byte, rec Record) ([]byte, error) {
+	if uint64(len(rec.Data)) > math.MaxUint32 {
+		return b, ErrRecordTooLarge
+	}
+	b = binary.BigEndian.AppendUint64(b, rec.StreamID)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(rec.Data)))
+	return append(b, rec.Data...), nil
+}
+
+// WriteRecord writes a record to an encoded file.
+func WriteRecord(w io.Writer, rec Record) error {
+	b, err := AppendRecord(make([]byte, 0, 12+len(rec.Data)), rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
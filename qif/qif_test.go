@@ -0,0 +1,138 @@
+package qif
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/qpack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	const data = `# a comment
+:method	GET
+:path	/
+empty-value
+
+# another comment
+:status	200
+x-tab	foo	bar
+
+
+:status	404
+`
+	blocks, err := Read(strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, [][]qpack.HeaderField{
+		{{Name: ":method", Value: "GET"}, {Name: ":path", Value: "/"}, {Name: "empty-value"}},
+		{{Name: ":status", Value: "200"}, {Name: "x-tab", Value: "foo\tbar"}},
+		{{Name: ":status", Value: "404"}},
+	}, blocks)
+}
+
+func TestReadWithoutTrailingNewline(t *testing.T) {
+	blocks, err := Read(strings.NewReader("foo\tbar"))
+	require.NoError(t, err)
+	require.Equal(t, [][]qpack.HeaderField{{{Name: "foo", Value: "bar"}}}, blocks)
+}
+
+func TestWrite(t *testing.T) {
+	blocks := [][]qpack.HeaderField{
+		{{Name: ":method", Value: "GET"}, {Name: "empty-value"}},
+		{{Name: ":status", Value: "200"}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, blocks))
+	require.Equal(t, ":method\tGET\nempty-value\t\n\n:status\t200\n", buf.String())
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	require.Equal(t, blocks, read)
+}
+
+func TestWriteInvalidField(t *testing.T) {
+	require.Error(t, Write(io.Discard, [][]qpack.HeaderField{{{Name: "foo", Value: "bar\nbaz"}}}))
+	require.Error(t, Write(io.Discard, [][]qpack.HeaderField{{{Name: "foo\tbar", Value: "baz"}}}))
+}
+
+func TestRecords(t *testing.T) {
+	records := []Record{
+		{StreamID: EncoderStreamID, Data: []byte{0x3f, 0xe1, 0x1f}},
+		{StreamID: 4, Data: []byte("foobar")},
+		{StreamID: 1 << 40, Data: []byte{}},
+	}
+	var buf bytes.Buffer
+	for _, rec := range records {
+		require.NoError(t, WriteRecord(&buf, rec))
+	}
+	require.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0x3f, 0xe1, 0x1f}, buf.Bytes()[:15])
+
+	data := buf.Bytes()
+	for _, rec := range records {
+		r, err := ReadRecord(&buf)
+		require.NoError(t, err)
+		require.Equal(t, rec, r)
+	}
+	_, err := ReadRecord(&buf)
+	require.Equal(t, io.EOF, err)
+
+	// incomplete records
+	for _, l := range []int{1, 11, 12, 14} {
+		_, err := ReadRecord(bytes.NewReader(data[:l]))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+	// a corrupt length doesn't cause the entire record to be allocated upfront
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = ReadRecord(bytes.NewReader([]byte{0, 0, 0, 0, 0, 0, 0, 4, 0xff, 0xff, 0xff, 0xff, 'f', 'o', 'o'}))
+	runtime.ReadMemStats(&after)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<16))
+}
+
+func TestReadEncodedFile(t *testing.T) {
+	f, err := os.Open("../example/fb-req-hq.out.0.0.0")
+	require.NoError(t, err)
+	defer f.Close()
+
+	dec := qpack.NewDecoder()
+	var numRecords int
+	for {
+		rec, err := ReadRecord(f)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.NotEqual(t, uint64(EncoderStreamID), rec.StreamID)
+		_, err = dec.DecodeAll(rec.Data)
+		require.NoError(t, err)
+		numRecords++
+	}
+	require.NotZero(t, numRecords)
+}
+
+func TestParseEncodedFileName(t *testing.T) {
+	name, err := ParseEncodedFileName("fb-req.out.256.100.1")
+	require.NoError(t, err)
+	require.Equal(t, EncodedFileName{QIF: "fb-req", TableSize: 256, MaxBlockedStreams: 100, ImmediateAck: true}, name)
+
+	name, err = ParseEncodedFileName("netbsd.out.0.0.0")
+	require.NoError(t, err)
+	require.Equal(t, EncodedFileName{QIF: "netbsd"}, name)
+
+	for _, name := range []string{
+		"fb-req.qif",
+		"fb-req.in.256.100.1",
+		"fb-req.out.256.100",
+		"fb-req.out.foo.100.1",
+		"fb-req.out.256.-1.1",
+		"fb-req.out.256.100.2",
+	} {
+		_, err := ParseEncodedFileName(name)
+		require.Error(t, err, name)
+	}
+}
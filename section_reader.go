@@ -0,0 +1,135 @@
+package qpack
+
+import (
+	"errors"
+	"io"
+)
+
+// A SectionReader decodes a field section that is received in multiple chunks,
+// e.g. when a HEADERS frame is spread over multiple QUIC STREAM frames.
+// Header fields are passed to the callback as soon as they are complete,
+// without waiting for the rest of the field section.
+//
+// If the field section is blocked on the encoder stream, the data is buffered.
+// Decoding is resumed by the next call to Write or Close,
+// once the channel returned by Decoder.Unblocked is closed.
+type SectionReader struct {
+	s   sectionDecoder
+	fn  func(HeaderField) error
+	buf []byte // buf[off:] hasn't been decoded yet, usually an incomplete field line
+	off int
+	err error // set once decoding fails
+}
+
+var _ io.WriteCloser = &SectionReader{}
+
+// NewSectionReader returns a SectionReader for a field section received on the given stream.
+// fn is called for every header field. If it returns an error, decoding is aborted,
+// and the error is returned from Write or Close.
+func (d *Decoder) NewSectionReader(streamID uint64, fn func(HeaderField) error) *SectionReader {
+	return &SectionReader{
+		s: sectionDecoder{
+			d:            d,
+			streamID:     streamID,
+			isStream:     true,
+			fieldSection: fieldSection{remaining: d.maxFieldSectionSize},
+		},
+		fn: fn,
+	}
+}
+
+// Write decodes all header fields that are complete, and buffers the remaining data.
+// It returns an error if decoding fails, or if the callback returns an error.
+func (r *SectionReader) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.buf = append(r.buf, p...)
+	if _, err := r.decode(); err != nil {
+		r.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close is called after the last chunk of the field section has been written.
+// It returns an error if the field section is incomplete.
+// If the field section references the dynamic table, a Section Acknowledgment is sent.
+//
+// If the field section is blocked on the encoder stream, Close returns ErrBlocked.
+// Close can then be called again once the stream is unblocked.
+func (r *SectionReader) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+	blocked, err := r.decode()
+	if err != nil {
+		r.err = err
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	if !r.s.readPrefix {
+		r.err = r.s.prefixError(io.ErrUnexpectedEOF)
+		return r.err
+	}
+	if len(r.buf) > r.off {
+		r.err = r.s.decodingError(r.s.offset, r.s.fieldLines, fieldLineType(r.buf[r.off]), io.ErrUnexpectedEOF)
+		return r.err
+	}
+	if err := r.s.finish(); err != io.EOF {
+		r.err = err
+		return err
+	}
+	return nil
+}
+
+// decode decodes all complete field lines in the buffer.
+// It reports if the field section is blocked on the encoder stream.
+func (r *SectionReader) decode() (blocked bool, _ error) {
+	r.s.p = r.buf[r.off:]
+	defer func() {
+		r.off = len(r.buf) - len(r.s.p)
+		// Only move the remaining data to the front once more than half of the buffer has been decoded,
+		// so that every byte is copied a constant number of times on average.
+		if r.off > len(r.buf)/2 {
+			r.buf = append(r.buf[:0], r.buf[r.off:]...)
+			r.off = 0
+		}
+	}()
+
+	if err := r.s.start(); err != nil {
+		if err == ErrBlocked {
+			return true, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	for len(r.s.p) > 0 {
+		// If the field line is incomplete, it is parsed again once more data has been received.
+		p, remaining := r.s.p, r.s.remaining
+		var fl fieldLine
+		if err := r.s.next(&fl); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				// Don't buffer a field line that exceeds the field section size once it is complete.
+				if r.s.minDecodedLen(&fl) > r.s.remaining {
+					return false, ErrFieldSectionTooLarge
+				}
+				r.s.p, r.s.remaining = p, remaining
+				return false, nil
+			}
+			return false, err
+		}
+		hf, err := r.s.headerField(&fl)
+		if err != nil {
+			return false, err
+		}
+		if err := r.fn(hf); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
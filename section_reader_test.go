@@ -0,0 +1,202 @@
+package qpack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/quic-go/qpack/wire"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSectionReader(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		testcase
+	}{
+		{name: "literal field without name reference", testcase: literalFieldWithoutNameReference},
+		{name: "literal field with name reference", testcase: literalFieldWithNameReference},
+		{name: "literal field with Huffman encoding", testcase: literalFieldWithHuffmanEncoding},
+		{name: "indexed field", testcase: indexedField},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// split the field section at every position
+			for i := 0; i <= len(tc.Data); i++ {
+				var hfs []HeaderField
+				r := NewDecoder().NewSectionReader(0, func(hf HeaderField) error {
+					hfs = append(hfs, hf)
+					return nil
+				})
+				n, err := r.Write(tc.Data[:i])
+				require.NoError(t, err)
+				require.Equal(t, i, n)
+				n, err = r.Write(tc.Data[i:])
+				require.NoError(t, err)
+				require.Equal(t, len(tc.Data)-i, n)
+				require.NoError(t, r.Close())
+				require.Equal(t, tc.Expected, hfs)
+			}
+
+			t.Run("byte by byte", func(t *testing.T) {
+				var hfs []HeaderField
+				r := NewDecoder().NewSectionReader(0, func(hf HeaderField) error {
+					hfs = append(hfs, hf)
+					return nil
+				})
+				for _, b := range tc.Data {
+					_, err := r.Write([]byte{b})
+					require.NoError(t, err)
+				}
+				require.NoError(t, r.Close())
+				require.Equal(t, tc.Expected, hfs)
+			})
+		})
+	}
+}
+
+func TestSectionReaderEmitsCompleteFields(t *testing.T) {
+	data := literalFieldWithNameReference.Data
+	var hfs []HeaderField
+	r := NewDecoder().NewSectionReader(0, func(hf HeaderField) error {
+		hfs = append(hfs, hf)
+		return nil
+	})
+	// the prefix (2 bytes), and the first field line: name reference (2 bytes), value length (1 byte) and value
+	firstLen := 2 + 2 + 1 + len(loremIpsum1)
+	_, err := r.Write(data[:firstLen-1])
+	require.NoError(t, err)
+	require.Empty(t, hfs)
+	_, err = r.Write(data[firstLen-1 : firstLen+1])
+	require.NoError(t, err)
+	require.Equal(t, literalFieldWithNameReference.Expected[:1], hfs)
+	// the partial field line is buffered
+	require.Equal(t, data[firstLen:firstLen+1], r.buf[r.off:])
+	_, err = r.Write(data[firstLen+1:])
+	require.NoError(t, err)
+	require.Equal(t, literalFieldWithNameReference.Expected, hfs)
+	require.NoError(t, r.Close())
+}
+
+func TestSectionReaderDynamicTable(t *testing.T) {
+	var decoderStream bytes.Buffer
+	dec := NewDecoder(
+		WithMaxTableCapacity(200),
+		WithMaxBlockedStreams(1),
+		WithDecoderStream(&decoderStream),
+	)
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendSetDynamicTableCapacity(nil, 200)))
+
+	// maxEntries = 6, fullRange = 12, Required Insert Count = 1
+	data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{EncodedInsertCount: 2}) // Base = 1
+	data = append(data, 0xc0|17)                                                               // static table: :method GET
+	data = append(data, 0x80|0)                                                                // absolute index 0
+
+	var hfs []HeaderField
+	r := dec.NewSectionReader(4, func(hf HeaderField) error {
+		hfs = append(hfs, hf)
+		return nil
+	})
+	_, err := r.Write(data[:3])
+	require.NoError(t, err)
+	require.Empty(t, hfs) // the stream is blocked
+	require.ErrorIs(t, r.Close(), ErrBlocked)
+	_, err = r.Write(data[3:])
+	require.NoError(t, err)
+	require.Empty(t, hfs)
+
+	require.NoError(t, dec.HandleEncoderStream(wire.AppendInsertWithLiteralName(nil, "foo", false, "bar", false)))
+	select {
+	case <-dec.Unblocked(4):
+	default:
+		t.Fatal("stream should have been unblocked")
+	}
+	decoderStream.Reset()
+	require.NoError(t, r.Close())
+	require.Equal(t, []HeaderField{{Name: ":method", Value: "GET"}, {Name: "foo", Value: "bar"}}, hfs)
+	require.Equal(t, wire.AppendSectionAcknowledgment(nil, 4), decoderStream.Bytes())
+}
+
+func TestSectionReaderErrors(t *testing.T) {
+	noop := func(HeaderField) error { return nil }
+
+	t.Run("incomplete field section", func(t *testing.T) {
+		for _, data := range [][]byte{
+			nil,
+			literalFieldWithNameReference.Data[:1],
+			literalFieldWithNameReference.Data[:len(literalFieldWithNameReference.Data)-1],
+		} {
+			r := NewDecoder().NewSectionReader(0, noop)
+			_, err := r.Write(data)
+			require.NoError(t, err)
+			err = r.Close()
+			var qerr *Error
+			require.ErrorAs(t, err, &qerr)
+			require.Equal(t, ErrCodeDecompressionFailed, qerr.Code)
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		}
+	})
+
+	t.Run("invalid field line", func(t *testing.T) {
+		data := wire.AppendVarInt(nil, 6, 10000)
+		data[0] ^= 0x80 | 0x40
+		r := NewDecoder().NewSectionReader(0, noop)
+		_, err := r.Write(insertPrefix(data))
+		var qerr *Error
+		require.ErrorAs(t, err, &qerr)
+		require.Equal(t, &DecodingError{Offset: 2, Type: wire.IndexedFieldLine, Err: invalidIndexError(10000)}, qerr.Err)
+		// the error is returned from subsequent calls
+		_, err = r.Write([]byte{0x80 | 0x40 | 17})
+		require.ErrorAs(t, err, &qerr)
+		require.ErrorAs(t, r.Close(), &qerr)
+	})
+
+	t.Run("error position", func(t *testing.T) {
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{})
+		data = wire.AppendIndexedFieldLine(data, true, 17)
+		data = wire.AppendLiteralFieldLineWithLiteralName(data, false, "foo", false, "bar", false)
+		r := NewDecoder().NewSectionReader(0, noop)
+		// write the field section in chunks, and cut off the last byte
+		_, err := r.Write(data[:3])
+		require.NoError(t, err)
+		_, err = r.Write(data[3 : len(data)-1])
+		require.NoError(t, err)
+		var derr *DecodingError
+		require.ErrorAs(t, r.Close(), &derr)
+		require.Equal(t, &DecodingError{Offset: 3, FieldLine: 1, Type: wire.LiteralFieldLineWithLiteralName, Err: io.ErrUnexpectedEOF}, derr)
+
+		r = NewDecoder().NewSectionReader(0, noop)
+		_, err = r.Write(data[:1])
+		require.NoError(t, err)
+		require.ErrorAs(t, r.Close(), &derr)
+		require.Equal(t, -1, derr.FieldLine)
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		testErr := errors.New("test error")
+		r := NewDecoder().NewSectionReader(0, func(HeaderField) error { return testErr })
+		_, err := r.Write(indexedField.Data)
+		require.ErrorIs(t, err, testErr)
+		require.ErrorIs(t, r.Close(), testErr)
+	})
+
+	t.Run("field section too large", func(t *testing.T) {
+		r := NewDecoder(WithMaxFieldSectionSize(100)).NewSectionReader(0, noop)
+		_, err := r.Write(literalFieldWithNameReference.Data)
+		require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+	})
+
+	t.Run("incomplete field line too large", func(t *testing.T) {
+		value := bytes.Repeat([]byte("a"), 10000)
+		data := wire.AppendFieldSectionPrefix(nil, wire.FieldSectionPrefix{})
+		data = wire.AppendLiteralFieldLineWithLiteralName(data, false, "foo", false, string(value), false)
+		r := NewDecoder(WithMaxFieldSectionSize(100)).NewSectionReader(0, noop)
+		// The declared length of the value is known after the first few bytes,
+		// so the field line is rejected before it is buffered.
+		_, err := r.Write(data[:10])
+		require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+		_, err = r.Write(data[10:])
+		require.ErrorIs(t, err, ErrFieldSectionTooLarge)
+	})
+}
@@ -0,0 +1,281 @@
+package qpack
+
+import (
+	"fmt"
+	"io"
+)
+
+// A FieldSectionKind is the kind of field section that is validated.
+type FieldSectionKind uint8
+
+const (
+	// RequestHeaderSection is the header section of a request.
+	RequestHeaderSection FieldSectionKind = iota + 1
+	// ResponseHeaderSection is the header section of a response.
+	ResponseHeaderSection
+	// TrailerSection is the trailer section of a request or a response.
+	TrailerSection
+)
+
+func (k FieldSectionKind) String() string {
+	switch k {
+	case RequestHeaderSection:
+		return "request headers"
+	case ResponseHeaderSection:
+		return "response headers"
+	case TrailerSection:
+		return "trailers"
+	default:
+		return fmt.Sprintf("unknown field section kind: %d", uint8(k))
+	}
+}
+
+// A MalformedError is returned when a field section violates the rules
+// of Sections 4.2 and 4.3 of RFC 9114.
+// A malformed request or response must be treated as a stream error of type H3_MESSAGE_ERROR,
+// see Section 4.1.2 of RFC 9114.
+type MalformedError struct {
+	Field string // the name of the offending field
+	Rule  string // the rule that was violated
+}
+
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("malformed field section: %s: %q", e.Rule, e.Field)
+}
+
+type pseudoHeader uint8
+
+const (
+	pseudoMethod pseudoHeader = 1 << iota
+	pseudoScheme
+	pseudoAuthority
+	pseudoPath
+	pseudoProtocol
+	pseudoStatus
+)
+
+var requestPseudoHeaders = map[string]pseudoHeader{
+	":method":    pseudoMethod,
+	":scheme":    pseudoScheme,
+	":authority": pseudoAuthority,
+	":path":      pseudoPath,
+	":protocol":  pseudoProtocol, // extended CONNECT, see RFC 9220
+}
+
+// connectionSpecificFields must not be used in HTTP/3, see Section 4.2 of RFC 9114.
+var connectionSpecificFields = map[string]struct{}{
+	"connection":        {},
+	"keep-alive":        {},
+	"proxy-connection":  {},
+	"transfer-encoding": {},
+	"upgrade":           {},
+}
+
+// A Validator checks that a field section is well-formed,
+// as described in Sections 4.2 and 4.3 of RFC 9114.
+// The header fields are passed to Field in the order they were decoded,
+// and Done is called after the last header field.
+// A Validator can only be used for a single field section.
+type Validator struct {
+	kind FieldSectionKind
+
+	pseudo  pseudoHeader // the pseudo-header fields received
+	regular bool         // set once a regular field was received
+
+	method, scheme, authority string
+	hasHost                   bool
+}
+
+// NewValidator returns a new Validator for a field section of the given kind.
+func NewValidator(kind FieldSectionKind) *Validator {
+	return &Validator{kind: kind}
+}
+
+// Field validates the next header field.
+func (v *Validator) Field(hf HeaderField) error {
+	if err := validateFieldName(hf.Name); err != nil {
+		return err
+	}
+	for i := 0; i < len(hf.Value); i++ {
+		switch hf.Value[i] {
+		case 0, '\r', '\n':
+			return &MalformedError{Field: hf.Name, Rule: "invalid character in field value"}
+		}
+	}
+	if hf.Name[0] == ':' {
+		return v.pseudoHeaderField(hf)
+	}
+
+	v.regular = true
+	if _, ok := connectionSpecificFields[hf.Name]; ok {
+		return &MalformedError{Field: hf.Name, Rule: "connection-specific field"}
+	}
+	switch hf.Name {
+	case "te":
+		if hf.Value != "trailers" {
+			return &MalformedError{Field: hf.Name, Rule: `TE field with a value other than "trailers"`}
+		}
+	case "host":
+		// The pseudo-header fields were all received before the first regular field.
+		if v.kind == RequestHeaderSection && v.pseudo&pseudoAuthority != 0 && hf.Value != v.authority {
+			return &MalformedError{Field: hf.Name, Rule: "host field differs from :authority pseudo-header field"}
+		}
+		v.hasHost = true
+	}
+	return nil
+}
+
+func (v *Validator) pseudoHeaderField(hf HeaderField) error {
+	if v.kind == TrailerSection {
+		return &MalformedError{Field: hf.Name, Rule: "pseudo-header field in trailers"}
+	}
+	if v.regular {
+		return &MalformedError{Field: hf.Name, Rule: "pseudo-header field after regular field"}
+	}
+	var p pseudoHeader
+	if v.kind == RequestHeaderSection {
+		p = requestPseudoHeaders[hf.Name]
+	} else if hf.Name == ":status" {
+		p = pseudoStatus
+	}
+	if p == 0 {
+		return &MalformedError{Field: hf.Name, Rule: fmt.Sprintf("pseudo-header field not allowed in %s", v.kind)}
+	}
+	if v.pseudo&p != 0 {
+		return &MalformedError{Field: hf.Name, Rule: "duplicate pseudo-header field"}
+	}
+	v.pseudo |= p
+
+	switch p {
+	case pseudoMethod:
+		v.method = hf.Value
+	case pseudoScheme:
+		v.scheme = hf.Value
+	case pseudoAuthority:
+		v.authority = hf.Value
+	case pseudoPath:
+		if hf.Value == "" {
+			return &MalformedError{Field: hf.Name, Rule: "empty :path pseudo-header field"}
+		}
+	case pseudoProtocol:
+		// checked in validateRequest, once :method is known
+	case pseudoStatus:
+		if len(hf.Value) != 3 || !isDigit(hf.Value[0]) || !isDigit(hf.Value[1]) || !isDigit(hf.Value[2]) {
+			return &MalformedError{Field: hf.Name, Rule: "invalid status code"}
+		}
+	}
+	return nil
+}
+
+// Done is called after the last header field.
+// It checks that all mandatory pseudo-header fields were received.
+func (v *Validator) Done() error {
+	switch v.kind {
+	case RequestHeaderSection:
+		return v.validateRequest()
+	case ResponseHeaderSection:
+		if v.pseudo&pseudoStatus == 0 {
+			return &MalformedError{Field: ":status", Rule: "missing pseudo-header field"}
+		}
+	case TrailerSection:
+		// trailers don't contain any pseudo-header fields
+	}
+	return nil
+}
+
+// validateRequest validates the pseudo-header fields of a request, see Section 4.3.1 of RFC 9114.
+func (v *Validator) validateRequest() error {
+	if v.pseudo&pseudoMethod == 0 {
+		return &MalformedError{Field: ":method", Rule: "missing pseudo-header field"}
+	}
+	if v.pseudo&pseudoProtocol != 0 && v.method != "CONNECT" {
+		return &MalformedError{Field: ":protocol", Rule: "pseudo-header field not allowed in non-CONNECT request"}
+	}
+	// A CONNECT request that is not an extended CONNECT request only contains :method and :authority,
+	// see Section 4.4 of RFC 9114.
+	if v.method == "CONNECT" && v.pseudo&pseudoProtocol == 0 {
+		if v.pseudo&pseudoAuthority == 0 {
+			return &MalformedError{Field: ":authority", Rule: "missing pseudo-header field"}
+		}
+		if v.pseudo&pseudoScheme != 0 {
+			return &MalformedError{Field: ":scheme", Rule: "pseudo-header field not allowed in CONNECT request"}
+		}
+		if v.pseudo&pseudoPath != 0 {
+			return &MalformedError{Field: ":path", Rule: "pseudo-header field not allowed in CONNECT request"}
+		}
+		return nil
+	}
+	if v.pseudo&pseudoScheme == 0 {
+		return &MalformedError{Field: ":scheme", Rule: "missing pseudo-header field"}
+	}
+	if v.pseudo&pseudoPath == 0 {
+		return &MalformedError{Field: ":path", Rule: "missing pseudo-header field"}
+	}
+	if v.scheme == "http" || v.scheme == "https" {
+		if v.pseudo&pseudoAuthority == 0 && !v.hasHost {
+			return &MalformedError{Field: ":authority", Rule: "missing :authority pseudo-header field and host field"}
+		}
+	}
+	return nil
+}
+
+// validateFieldName checks that a field name is a lowercase token, see Section 5.1 of RFC 9110.
+// Pseudo-header field names start with a colon.
+func validateFieldName(name string) error {
+	if len(name) == 0 || name == ":" {
+		return &MalformedError{Field: name, Rule: "empty field name"}
+	}
+	start := 0
+	if name[0] == ':' {
+		start = 1
+	}
+	for i := start; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			return &MalformedError{Field: name, Rule: "uppercase character in field name"}
+		}
+		if !isTokenChar(c) {
+			return &MalformedError{Field: name, Rule: "invalid character in field name"}
+		}
+	}
+	return nil
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', isDigit(c):
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// Validate returns a DecodeFunc that validates the header fields decoded by f,
+// see Validator. Header fields are returned as soon as they have been validated.
+// If a header field violates the rules, a *MalformedError is returned.
+// Missing pseudo-header fields are detected after the last header field,
+// in which case the *MalformedError is returned instead of io.EOF.
+func (f DecodeFunc) Validate(kind FieldSectionKind) DecodeFunc {
+	v := NewValidator(kind)
+	return func() (HeaderField, error) {
+		hf, err := f()
+		if err == io.EOF {
+			if err := v.Done(); err != nil {
+				return HeaderField{}, err
+			}
+			return HeaderField{}, io.EOF
+		}
+		if err != nil {
+			return hf, err
+		}
+		if err := v.Field(hf); err != nil {
+			return HeaderField{}, err
+		}
+		return hf, nil
+	}
+}
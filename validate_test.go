@@ -0,0 +1,330 @@
+package qpack
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func validRequest(extra ...HeaderField) []HeaderField {
+	return append([]HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":authority", Value: "quic-go.net"},
+		{Name: ":path", Value: "/"},
+	}, extra...)
+}
+
+func TestValidator(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		kind   FieldSectionKind
+		fields []HeaderField
+		field  string // the offending field, if the field section is malformed
+		rule   string
+	}{
+		{
+			name:   "valid request",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "user-agent", Value: "quic-go"}, HeaderField{Name: "te", Value: "trailers"}),
+		},
+		{
+			name: "valid request using the host field",
+			kind: RequestHeaderSection,
+			fields: []HeaderField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":path", Value: "/"},
+				{Name: "host", Value: "quic-go.net"},
+			},
+		},
+		{
+			name:   "valid CONNECT request",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "CONNECT"}, {Name: ":authority", Value: "quic-go.net:443"}},
+		},
+		{
+			name: "valid extended CONNECT request",
+			kind: RequestHeaderSection,
+			fields: []HeaderField{
+				{Name: ":method", Value: "CONNECT"},
+				{Name: ":protocol", Value: "webtransport"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":authority", Value: "quic-go.net"},
+				{Name: ":path", Value: "/wt"},
+			},
+		},
+		{
+			name:   "valid response",
+			kind:   ResponseHeaderSection,
+			fields: []HeaderField{{Name: ":status", Value: "200"}, {Name: "content-type", Value: "text/html"}},
+		},
+		{
+			name:   "valid trailers",
+			kind:   TrailerSection,
+			fields: []HeaderField{{Name: "grpc-status", Value: "0"}},
+		},
+		{
+			name:   "empty trailers",
+			kind:   TrailerSection,
+			fields: nil,
+		},
+		{
+			name:   "uppercase field name",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "User-Agent", Value: "quic-go"}),
+			field:  "User-Agent",
+			rule:   "uppercase character in field name",
+		},
+		{
+			name:   "uppercase pseudo-header field name",
+			kind:   ResponseHeaderSection,
+			fields: []HeaderField{{Name: ":Status", Value: "200"}},
+			field:  ":Status",
+			rule:   "uppercase character in field name",
+		},
+		{
+			name:   "invalid character in field name",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "foo bar", Value: "baz"}),
+			field:  "foo bar",
+			rule:   "invalid character in field name",
+		},
+		{
+			name:   "empty field name",
+			kind:   TrailerSection,
+			fields: []HeaderField{{Name: "", Value: "baz"}},
+			field:  "",
+			rule:   "empty field name",
+		},
+		{
+			name:   "CR in field value",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "foo", Value: "bar\rbaz"}),
+			field:  "foo",
+			rule:   "invalid character in field value",
+		},
+		{
+			name:   "LF in field value",
+			kind:   ResponseHeaderSection,
+			fields: []HeaderField{{Name: ":status", Value: "200"}, {Name: "foo", Value: "bar\nbaz"}},
+			field:  "foo",
+			rule:   "invalid character in field value",
+		},
+		{
+			name:   "NUL in field value",
+			kind:   TrailerSection,
+			fields: []HeaderField{{Name: "foo", Value: "bar\x00"}},
+			field:  "foo",
+			rule:   "invalid character in field value",
+		},
+		{
+			name:   "connection-specific field",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "transfer-encoding", Value: "chunked"}),
+			field:  "transfer-encoding",
+			rule:   "connection-specific field",
+		},
+		{
+			name:   "TE field",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "te", Value: "gzip"}),
+			field:  "te",
+			rule:   `TE field with a value other than "trailers"`,
+		},
+		{
+			name:   "pseudo-header field after regular field",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "GET"}, {Name: "foo", Value: "bar"}, {Name: ":path", Value: "/"}},
+			field:  ":path",
+			rule:   "pseudo-header field after regular field",
+		},
+		{
+			name:   "unknown pseudo-header field",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":foo", Value: "bar"}},
+			field:  ":foo",
+			rule:   "pseudo-header field not allowed in request headers",
+		},
+		{
+			name:   "response pseudo-header field in request",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":status", Value: "200"}},
+			field:  ":status",
+			rule:   "pseudo-header field not allowed in request headers",
+		},
+		{
+			name:   "request pseudo-header field in response",
+			kind:   ResponseHeaderSection,
+			fields: []HeaderField{{Name: ":status", Value: "200"}, {Name: ":path", Value: "/"}},
+			field:  ":path",
+			rule:   "pseudo-header field not allowed in response headers",
+		},
+		{
+			name:   "pseudo-header field in trailers",
+			kind:   TrailerSection,
+			fields: []HeaderField{{Name: ":status", Value: "200"}},
+			field:  ":status",
+			rule:   "pseudo-header field in trailers",
+		},
+		{
+			name:   "duplicate pseudo-header field",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: ":path", Value: "/foo"}),
+			field:  ":path",
+			rule:   "duplicate pseudo-header field",
+		},
+		{
+			name:   "empty :path",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "GET"}, {Name: ":path", Value: ""}},
+			field:  ":path",
+			rule:   "empty :path pseudo-header field",
+		},
+		{
+			name:   "invalid status code",
+			kind:   ResponseHeaderSection,
+			fields: []HeaderField{{Name: ":status", Value: "20"}},
+			field:  ":status",
+			rule:   "invalid status code",
+		},
+		{
+			name:   "missing :status",
+			kind:   ResponseHeaderSection,
+			fields: []HeaderField{{Name: "content-type", Value: "text/html"}},
+			field:  ":status",
+			rule:   "missing pseudo-header field",
+		},
+		{
+			name:   "missing :method",
+			kind:   RequestHeaderSection,
+			fields: validRequest()[1:],
+			field:  ":method",
+			rule:   "missing pseudo-header field",
+		},
+		{
+			name:   "missing :scheme",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "GET"}, {Name: ":authority", Value: "quic-go.net"}, {Name: ":path", Value: "/"}},
+			field:  ":scheme",
+			rule:   "missing pseudo-header field",
+		},
+		{
+			name:   "missing :path",
+			kind:   RequestHeaderSection,
+			fields: validRequest()[:3],
+			field:  ":path",
+			rule:   "missing pseudo-header field",
+		},
+		{
+			name:   "missing :authority and host",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "https"}, {Name: ":path", Value: "/"}},
+			field:  ":authority",
+			rule:   "missing :authority pseudo-header field and host field",
+		},
+		{
+			name:   "host differs from :authority",
+			kind:   RequestHeaderSection,
+			fields: validRequest(HeaderField{Name: "host", Value: "example.com"}),
+			field:  "host",
+			rule:   "host field differs from :authority pseudo-header field",
+		},
+		{
+			name:   "CONNECT request without :authority",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "CONNECT"}},
+			field:  ":authority",
+			rule:   "missing pseudo-header field",
+		},
+		{
+			name:   "CONNECT request with :path",
+			kind:   RequestHeaderSection,
+			fields: []HeaderField{{Name: ":method", Value: "CONNECT"}, {Name: ":authority", Value: "quic-go.net:443"}, {Name: ":path", Value: "/"}},
+			field:  ":path",
+			rule:   "pseudo-header field not allowed in CONNECT request",
+		},
+		{
+			name: ":protocol in a GET request",
+			kind: RequestHeaderSection,
+			fields: []HeaderField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":protocol", Value: "websocket"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":authority", Value: "quic-go.net"},
+				{Name: ":path", Value: "/"},
+			},
+			field: ":protocol",
+			rule:  "pseudo-header field not allowed in non-CONNECT request",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewValidator(tc.kind)
+			var err error
+			for _, hf := range tc.fields {
+				if err = v.Field(hf); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = v.Done()
+			}
+			if tc.rule == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			var merr *MalformedError
+			require.ErrorAs(t, err, &merr)
+			require.Equal(t, tc.field, merr.Field)
+			require.Equal(t, tc.rule, merr.Rule)
+		})
+	}
+}
+
+func TestDecodeFuncValidate(t *testing.T) {
+	encode := func(hfs []HeaderField) []byte {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		for _, hf := range hfs {
+			require.NoError(t, enc.WriteField(hf))
+		}
+		require.NoError(t, enc.Close())
+		return buf.Bytes()
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		hfs := validRequest(HeaderField{Name: "foo", Value: "bar"})
+		require.Equal(t, hfs, decodeAll(t, NewDecoder().Decode(encode(hfs)).Validate(RequestHeaderSection)))
+	})
+
+	t.Run("invalid field", func(t *testing.T) {
+		decode := NewDecoder().Decode(encode(validRequest(HeaderField{Name: "Foo", Value: "bar"}))).Validate(RequestHeaderSection)
+		for range validRequest() {
+			_, err := decode()
+			require.NoError(t, err)
+		}
+		_, err := decode()
+		require.EqualError(t, err, `malformed field section: uppercase character in field name: "Foo"`)
+	})
+
+	t.Run("missing pseudo-header field", func(t *testing.T) {
+		decode := NewDecoder().Decode(encode([]HeaderField{{Name: "foo", Value: "bar"}})).Validate(ResponseHeaderSection)
+		hf, err := decode()
+		require.NoError(t, err)
+		require.Equal(t, HeaderField{Name: "foo", Value: "bar"}, hf)
+		_, err = decode()
+		var merr *MalformedError
+		require.ErrorAs(t, err, &merr)
+		require.Equal(t, ":status", merr.Field)
+		require.NotErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("decoding errors", func(t *testing.T) {
+		_, err := NewDecoder().Decode([]byte{0x1}).Validate(TrailerSection)()
+		var qerr *Error
+		require.ErrorAs(t, err, &qerr)
+	})
+}
@@ -0,0 +1,11 @@
+// Package wire implements the wire representations of RFC 9204:
+// prefix integers and string literals, the field line representations,
+// as well as the encoder and decoder stream instructions.
+//
+// Unlike the qpack.Encoder, which chooses the representation for every header field itself,
+// this package leaves all choices to the caller, including the N and H bits.
+// It is intended for tests that need to produce or inspect specific representations.
+// This package doesn't maintain any dynamic table state, and doesn't check indices.
+//
+// Explain disassembles field sections and instruction streams, for debugging interop problems.
+package wire
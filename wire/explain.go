@@ -0,0 +1,267 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// staticTableSize is the number of entries in the static table, see Appendix A of RFC 9204.
+const staticTableSize = 99
+
+var (
+	errStaticIndexOutOfRange     = errors.New("static table index out of range")
+	errUnexpectedDynamicTableRef = errors.New("dynamic table reference in a field section with a Required Insert Count of 0")
+	errZeroInsertCountIncrement  = errors.New("insert count increment of 0")
+)
+
+// A DataType is the type of data passed to Explain.
+type DataType uint8
+
+const (
+	// FieldSection is an encoded field section, starting with the Encoded Field Section Prefix.
+	FieldSection DataType = iota + 1
+	// EncoderStream is data sent on the encoder stream.
+	EncoderStream
+	// DecoderStream is data sent on the decoder stream.
+	DecoderStream
+)
+
+func (t DataType) String() string {
+	switch t {
+	case FieldSection:
+		return "field section"
+	case EncoderStream:
+		return "encoder stream"
+	case DecoderStream:
+		return "decoder stream"
+	default:
+		return "unknown data type"
+	}
+}
+
+// An Instruction is a single representation found by Explain:
+// the Encoded Field Section Prefix, a field line, or an encoder or decoder instruction.
+type Instruction struct {
+	// Offset is the offset of the representation from the beginning of the data.
+	Offset int
+	// Raw are the bytes of the representation.
+	// If the representation couldn't be parsed, Raw contains all remaining data.
+	Raw []byte
+	// Kind is the name of the representation, as used in RFC 9204.
+	Kind string
+
+	// Exactly one of the following is set, unless the representation couldn't be parsed.
+	FieldSectionPrefix *FieldSectionPrefix
+	FieldLine          *FieldLine
+	EncoderInstruction *EncoderInstruction
+	DecoderInstruction *DecoderInstruction
+
+	// Err is the problem found with this representation, if any.
+	Err error
+}
+
+// maxRawBytes is the maximum number of raw bytes printed by Instruction.String.
+const maxRawBytes = 8
+
+// String formats the instruction as a single line: the offset,
+// (the beginning of) the raw bytes, and the decoded values.
+func (in Instruction) String() string {
+	var b strings.Builder
+	for i, c := range in.Raw[:min(len(in.Raw), maxRawBytes)] {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%02x", c)
+	}
+	if len(in.Raw) > maxRawBytes {
+		fmt.Fprintf(&b, " (+%d)", len(in.Raw)-maxRawBytes)
+	}
+	s := fmt.Sprintf("%04x  %-30s  %s", in.Offset, b.String(), in.describe())
+	if in.Err != nil {
+		s += fmt.Sprintf(" (error: %v)", in.Err)
+	}
+	return s
+}
+
+func (in Instruction) describe() string {
+	switch {
+	case in.FieldSectionPrefix != nil:
+		p := in.FieldSectionPrefix
+		return fmt.Sprintf("%s: Encoded Insert Count=%d S=%d Delta Base=%d", in.Kind, p.EncodedInsertCount, bit(p.Sign), p.DeltaBase)
+	case in.FieldLine != nil:
+		fl := in.FieldLine
+		switch fl.Type {
+		case IndexedFieldLine:
+			return fmt.Sprintf("%s: T=%d index=%d", in.Kind, bit(fl.Static), fl.Index)
+		case IndexedFieldLinePostBase:
+			return fmt.Sprintf("%s: index=%d", in.Kind, fl.Index)
+		case LiteralFieldLineWithNameReference:
+			return fmt.Sprintf("%s: N=%d T=%d index=%d value=%s",
+				in.Kind, bit(fl.NeverIndex), bit(fl.Static), fl.Index, describeString(fl.Value, fl.ValueHuffman))
+		case LiteralFieldLineWithPostBaseNameReference:
+			return fmt.Sprintf("%s: N=%d index=%d value=%s",
+				in.Kind, bit(fl.NeverIndex), fl.Index, describeString(fl.Value, fl.ValueHuffman))
+		case LiteralFieldLineWithLiteralName:
+			return fmt.Sprintf("%s: N=%d name=%s value=%s",
+				in.Kind, bit(fl.NeverIndex), describeString(fl.Name, fl.NameHuffman), describeString(fl.Value, fl.ValueHuffman))
+		}
+	case in.EncoderInstruction != nil:
+		ei := in.EncoderInstruction
+		switch ei.Type {
+		case SetDynamicTableCapacity:
+			return fmt.Sprintf("%s: capacity=%d", in.Kind, ei.Capacity)
+		case InsertWithNameReference:
+			return fmt.Sprintf("%s: T=%d index=%d value=%s", in.Kind, bit(ei.Static), ei.Index, describeString(ei.Value, ei.ValueHuffman))
+		case InsertWithLiteralName:
+			return fmt.Sprintf("%s: name=%s value=%s", in.Kind, describeString(ei.Name, ei.NameHuffman), describeString(ei.Value, ei.ValueHuffman))
+		case Duplicate:
+			return fmt.Sprintf("%s: index=%d", in.Kind, ei.Index)
+		}
+	case in.DecoderInstruction != nil:
+		di := in.DecoderInstruction
+		switch di.Type {
+		case SectionAcknowledgment, StreamCancellation:
+			return fmt.Sprintf("%s: stream=%d", in.Kind, di.StreamID)
+		case InsertCountIncrement:
+			return fmt.Sprintf("%s: increment=%d", in.Kind, di.Increment)
+		}
+	}
+	return in.Kind
+}
+
+func describeString(s string, huffman bool) string {
+	return fmt.Sprintf("%q (H=%d)", s, bit(huffman))
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Explain parses a field section, or data sent on the encoder or decoder stream,
+// and returns all representations it contains.
+// It doesn't need any dynamic table state, and is intended for debugging.
+//
+// Parsing continues after problems that don't prevent parsing the rest of the data,
+// like invalid Huffman-encoded strings and out-of-range static table indices.
+// These are reported in the Err field of the affected Instruction.
+// If the data is truncated or otherwise can't be parsed, the last Instruction covers the remaining data.
+// The returned error combines the errors of all instructions.
+func Explain(t DataType, p []byte) ([]Instruction, error) {
+	e := explainer{p: p}
+	switch t {
+	case FieldSection:
+		e.fieldSection()
+	case EncoderStream:
+		e.encoderStream()
+	case DecoderStream:
+		e.decoderStream()
+	default:
+		return nil, fmt.Errorf("unknown data type %d", t)
+	}
+	return e.instructions, errors.Join(e.errs...)
+}
+
+type explainer struct {
+	p            []byte
+	off          int
+	instructions []Instruction
+	errs         []error
+}
+
+// add adds an instruction, with the n bytes at the current offset.
+func (e *explainer) add(n int, in Instruction) {
+	in.Offset = e.off
+	in.Raw = e.p[e.off : e.off+n]
+	e.off += n
+	if in.Err != nil {
+		e.errs = append(e.errs, fmt.Errorf("offset %d: %s: %w", in.Offset, in.Kind, in.Err))
+	}
+	e.instructions = append(e.instructions, in)
+}
+
+// isFatal says if an error prevents parsing the rest of the data.
+func isFatal(err error) bool {
+	return err != nil && !errors.Is(err, hpack.ErrInvalidHuffman)
+}
+
+func (e *explainer) fieldSection() {
+	const kind = "Encoded Field Section Prefix"
+	prefix, rest, err := ParseFieldSectionPrefix(e.p)
+	if err != nil {
+		e.add(len(e.p), Instruction{Kind: kind, Err: err})
+		return
+	}
+	e.add(len(e.p)-len(rest), Instruction{Kind: kind, FieldSectionPrefix: &prefix})
+	for e.off < len(e.p) {
+		fl, rest, err := parseFieldLine(e.p[e.off:])
+		if isFatal(err) {
+			e.add(len(e.p)-e.off, Instruction{Kind: fl.Type.String(), Err: err})
+			return
+		}
+		e.add(len(e.p)-e.off-len(rest), Instruction{
+			Kind:      fl.Type.String(),
+			FieldLine: &fl,
+			Err:       errors.Join(err, checkFieldLine(prefix, fl)),
+		})
+	}
+}
+
+func checkFieldLine(prefix FieldSectionPrefix, fl FieldLine) error {
+	switch fl.Type {
+	case IndexedFieldLine, LiteralFieldLineWithNameReference:
+		if fl.Static {
+			return checkStaticIndex(fl.Index)
+		}
+		if prefix.EncodedInsertCount == 0 {
+			return errUnexpectedDynamicTableRef
+		}
+	case IndexedFieldLinePostBase, LiteralFieldLineWithPostBaseNameReference:
+		if prefix.EncodedInsertCount == 0 {
+			return errUnexpectedDynamicTableRef
+		}
+	case LiteralFieldLineWithLiteralName:
+		// doesn't reference any table entries
+	}
+	return nil
+}
+
+func checkStaticIndex(index uint64) error {
+	if index >= staticTableSize {
+		return errStaticIndexOutOfRange
+	}
+	return nil
+}
+
+func (e *explainer) encoderStream() {
+	for e.off < len(e.p) {
+		in, rest, err := parseEncoderInstruction(e.p[e.off:])
+		if isFatal(err) {
+			e.add(len(e.p)-e.off, Instruction{Kind: in.Type.String(), Err: err})
+			return
+		}
+		if in.Type == InsertWithNameReference && in.Static {
+			err = errors.Join(err, checkStaticIndex(in.Index))
+		}
+		e.add(len(e.p)-e.off-len(rest), Instruction{Kind: in.Type.String(), EncoderInstruction: &in, Err: err})
+	}
+}
+
+func (e *explainer) decoderStream() {
+	for e.off < len(e.p) {
+		in, rest, err := parseDecoderInstruction(e.p[e.off:])
+		if err != nil {
+			e.add(len(e.p)-e.off, Instruction{Kind: in.Type.String(), Err: err})
+			return
+		}
+		if in.Type == InsertCountIncrement && in.Increment == 0 {
+			err = errZeroInsertCountIncrement
+		}
+		e.add(len(e.p)-e.off-len(rest), Instruction{Kind: in.Type.String(), DecoderInstruction: &in, Err: err})
+	}
+}
@@ -0,0 +1,122 @@
+package wire
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestExplainFieldSection(t *testing.T) {
+	// Appendix B.2 of RFC 9204
+	b := AppendFieldSectionPrefix(nil, FieldSectionPrefix{EncodedInsertCount: 3, Sign: true, DeltaBase: 1})
+	b = AppendIndexedFieldLinePostBase(b, 0)
+	b = AppendLiteralFieldLineWithNameReference(b, true, true, 1, "/index.html", false)
+	b = AppendLiteralFieldLineWithLiteralName(b, false, "custom-key", true, "custom-value", false)
+
+	instructions, err := Explain(FieldSection, b)
+	require.NoError(t, err)
+	require.Len(t, instructions, 4)
+	require.Equal(t, Instruction{
+		Raw:                b[:2],
+		Kind:               "Encoded Field Section Prefix",
+		FieldSectionPrefix: &FieldSectionPrefix{EncodedInsertCount: 3, Sign: true, DeltaBase: 1},
+	}, instructions[0])
+	require.Equal(t, Instruction{
+		Offset:    2,
+		Raw:       b[2:3],
+		Kind:      "Indexed Field Line with Post-Base Index",
+		FieldLine: &FieldLine{Type: IndexedFieldLinePostBase, Index: 0},
+	}, instructions[1])
+	require.Equal(t, 3, instructions[2].Offset)
+	require.Equal(t, &FieldLine{Type: LiteralFieldLineWithNameReference, NeverIndex: true, Static: true, Index: 1, Value: "/index.html"}, instructions[2].FieldLine)
+	require.Equal(t, b[instructions[3].Offset:], instructions[3].Raw)
+	require.Equal(t, "Literal Field Line with Literal Name", instructions[3].Kind)
+
+	require.Equal(t, "0000  03 81                           Encoded Field Section Prefix: Encoded Insert Count=3 S=1 Delta Base=1", instructions[0].String())
+	require.Equal(t, "0002  10                              Indexed Field Line with Post-Base Index: index=0", instructions[1].String())
+	require.Equal(t, `0003  71 0b 2f 69 6e 64 65 78 (+5)    Literal Field Line with Name Reference: N=1 T=1 index=1 value="/index.html" (H=0)`, instructions[2].String())
+	require.Equal(t, `0010  2f 01 25 a8 49 e9 5b a9 (+15)   Literal Field Line with Literal Name: N=0 name="custom-key" (H=1) value="custom-value" (H=0)`, instructions[3].String())
+}
+
+func TestExplainFieldSectionProblems(t *testing.T) {
+	b := AppendFieldSectionPrefix(nil, FieldSectionPrefix{})
+	b = AppendIndexedFieldLine(b, true, 99)
+	b = AppendIndexedFieldLine(b, false, 0)
+	b = AppendLiteralFieldLineWithPostBaseNameReference(b, false, 0, "foo", false)
+	b = append(b, 0x51, 0x81, 0xff) // Literal Field Line with Name Reference, with an invalid Huffman-encoded value
+	b = AppendIndexedFieldLine(b, true, 17)
+	b = append(b, 0x51, 0x05, 'f', 'o') // truncated
+
+	instructions, err := Explain(FieldSection, b)
+	require.Error(t, err)
+	require.ErrorIs(t, err, errStaticIndexOutOfRange)
+	require.ErrorIs(t, err, errUnexpectedDynamicTableRef)
+	require.ErrorIs(t, err, hpack.ErrInvalidHuffman)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Contains(t, err.Error(), "offset 2: Indexed Field Line: static table index out of range")
+
+	require.Len(t, instructions, 7)
+	require.ErrorIs(t, instructions[1].Err, errStaticIndexOutOfRange)
+	require.ErrorIs(t, instructions[2].Err, errUnexpectedDynamicTableRef)
+	require.ErrorIs(t, instructions[3].Err, errUnexpectedDynamicTableRef)
+	require.ErrorIs(t, instructions[4].Err, hpack.ErrInvalidHuffman)
+	require.Equal(t, []byte{0x51, 0x81, 0xff}, instructions[4].Raw)
+	require.Equal(t, &FieldLine{Type: LiteralFieldLineWithNameReference, Static: true, Index: 1, ValueHuffman: true}, instructions[4].FieldLine)
+	require.NoError(t, instructions[5].Err)
+	require.Equal(t, &FieldLine{Type: IndexedFieldLine, Static: true, Index: 17}, instructions[5].FieldLine)
+	// the truncated field line
+	require.ErrorIs(t, instructions[6].Err, io.ErrUnexpectedEOF)
+	require.Equal(t, "Literal Field Line with Name Reference", instructions[6].Kind)
+	require.Nil(t, instructions[6].FieldLine)
+	require.Equal(t, []byte{0x51, 0x05, 'f', 'o'}, instructions[6].Raw)
+	require.Equal(t, "000e  51 05 66 6f                     Literal Field Line with Name Reference (error: unexpected EOF)", instructions[6].String())
+}
+
+func TestExplainInvalidFieldSectionPrefix(t *testing.T) {
+	instructions, err := Explain(FieldSection, []byte{0x01})
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, []Instruction{{Raw: []byte{0x01}, Kind: "Encoded Field Section Prefix", Err: io.ErrUnexpectedEOF}}, instructions)
+}
+
+func TestExplainEncoderStream(t *testing.T) {
+	b := AppendSetDynamicTableCapacity(nil, 220)
+	b = AppendInsertWithNameReference(b, true, 0, "www.example.com", false)
+	b = AppendInsertWithNameReference(b, true, 100, "foo", false)
+	b = append(b, 0x43, 'f', 'o', 'o', 0x83, 0xff, 0xff, 0xff) // Insert with Literal Name, with an invalid Huffman-encoded value
+	b = AppendDuplicate(b, 2)
+
+	instructions, err := Explain(EncoderStream, b)
+	require.Error(t, err)
+	require.Len(t, instructions, 5)
+	require.Equal(t, &EncoderInstruction{Type: SetDynamicTableCapacity, Capacity: 220}, instructions[0].EncoderInstruction)
+	require.Equal(t, `0003  c0 0f 77 77 77 2e 65 78 (+9)    Insert with Name Reference: T=1 index=0 value="www.example.com" (H=0)`, instructions[1].String())
+	require.ErrorIs(t, instructions[2].Err, errStaticIndexOutOfRange)
+	require.ErrorIs(t, instructions[3].Err, hpack.ErrInvalidHuffman)
+	require.Equal(t, &EncoderInstruction{Type: InsertWithLiteralName, Name: "foo", ValueHuffman: true}, instructions[3].EncoderInstruction)
+	require.Equal(t, "Duplicate", instructions[4].Kind)
+	require.Equal(t, &EncoderInstruction{Type: Duplicate, Index: 2}, instructions[4].EncoderInstruction)
+	require.NoError(t, instructions[4].Err)
+}
+
+func TestExplainDecoderStream(t *testing.T) {
+	b := AppendSectionAcknowledgment(nil, 4)
+	b = AppendInsertCountIncrement(b, 0)
+	b = AppendStreamCancellation(b, 8)
+	b = append(b, 0xff) // truncated Section Acknowledgment
+
+	instructions, err := Explain(DecoderStream, b)
+	require.Error(t, err)
+	require.Len(t, instructions, 4)
+	require.Equal(t, "0000  84                              Section Acknowledgment: stream=4", instructions[0].String())
+	require.Equal(t, "0001  00                              Insert Count Increment: increment=0 (error: insert count increment of 0)", instructions[1].String())
+	require.Equal(t, &DecoderInstruction{Type: StreamCancellation, StreamID: 8}, instructions[2].DecoderInstruction)
+	require.Equal(t, "Section Acknowledgment", instructions[3].Kind)
+	require.ErrorIs(t, instructions[3].Err, io.ErrUnexpectedEOF)
+}
+
+func TestExplainUnknownDataType(t *testing.T) {
+	_, err := Explain(42, []byte{0})
+	require.EqualError(t, err, "unknown data type 42")
+}
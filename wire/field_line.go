@@ -0,0 +1,228 @@
+package wire
+
+import (
+	"cmp"
+	"errors"
+	"io"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// A FieldSectionPrefix is the Encoded Field Section Prefix, see Section 4.5.1 of RFC 9204.
+type FieldSectionPrefix struct {
+	// EncodedInsertCount is the encoded Required Insert Count, see EncodeRequiredInsertCount.
+	EncodedInsertCount uint64
+	// Sign is set if the Base is smaller than the Required Insert Count.
+	Sign      bool
+	DeltaBase uint64
+}
+
+// EncodeRequiredInsertCount encodes the Required Insert Count, see Section 4.5.1.1 of RFC 9204.
+// maxTableCapacity is the decoder's SETTINGS_QPACK_MAX_TABLE_CAPACITY.
+// If it is smaller than 32, the dynamic table can't hold any entries,
+// so field sections can't reference it, and 0 is returned.
+func EncodeRequiredInsertCount(requiredInsertCount, maxTableCapacity uint64) uint64 {
+	maxEntries := maxTableCapacity / 32
+	if requiredInsertCount == 0 || maxEntries == 0 {
+		return 0
+	}
+	return requiredInsertCount%(2*maxEntries) + 1
+}
+
+// AppendFieldSectionPrefix appends an Encoded Field Section Prefix.
+func AppendFieldSectionPrefix(b []byte, prefix FieldSectionPrefix) []byte {
+	b = AppendVarInt(b, 8, prefix.EncodedInsertCount)
+	offset := len(b)
+	b = AppendVarInt(b, 7, prefix.DeltaBase)
+	if prefix.Sign {
+		b[offset] |= 0x80
+	}
+	return b
+}
+
+// ParseFieldSectionPrefix parses an Encoded Field Section Prefix.
+func ParseFieldSectionPrefix(p []byte) (_ FieldSectionPrefix, rest []byte, _ error) {
+	encodedInsertCount, rest, err := ReadVarInt(8, p)
+	if err != nil {
+		return FieldSectionPrefix{}, p, err
+	}
+	if len(rest) == 0 {
+		return FieldSectionPrefix{}, p, io.ErrUnexpectedEOF
+	}
+	sign := rest[0]&0x80 > 0
+	deltaBase, rest, err := ReadVarInt(7, rest)
+	if err != nil {
+		return FieldSectionPrefix{}, p, err
+	}
+	return FieldSectionPrefix{EncodedInsertCount: encodedInsertCount, Sign: sign, DeltaBase: deltaBase}, rest, nil
+}
+
+// A FieldLineType is the representation of a field line, see Section 4.5 of RFC 9204.
+type FieldLineType uint8
+
+const (
+	// IndexedFieldLine is an Indexed Field Line, see Section 4.5.2 of RFC 9204.
+	IndexedFieldLine FieldLineType = iota + 1
+	// IndexedFieldLinePostBase is an Indexed Field Line with Post-Base Index, see Section 4.5.3 of RFC 9204.
+	IndexedFieldLinePostBase
+	// LiteralFieldLineWithNameReference is a Literal Field Line with Name Reference, see Section 4.5.4 of RFC 9204.
+	LiteralFieldLineWithNameReference
+	// LiteralFieldLineWithPostBaseNameReference is a Literal Field Line with Post-Base Name Reference,
+	// see Section 4.5.5 of RFC 9204.
+	LiteralFieldLineWithPostBaseNameReference
+	// LiteralFieldLineWithLiteralName is a Literal Field Line with Literal Name, see Section 4.5.6 of RFC 9204.
+	LiteralFieldLineWithLiteralName
+)
+
+func (t FieldLineType) String() string {
+	switch t {
+	case IndexedFieldLine:
+		return "Indexed Field Line"
+	case IndexedFieldLinePostBase:
+		return "Indexed Field Line with Post-Base Index"
+	case LiteralFieldLineWithNameReference:
+		return "Literal Field Line with Name Reference"
+	case LiteralFieldLineWithPostBaseNameReference:
+		return "Literal Field Line with Post-Base Name Reference"
+	case LiteralFieldLineWithLiteralName:
+		return "Literal Field Line with Literal Name"
+	default:
+		return "unknown field line type"
+	}
+}
+
+// A FieldLine is a parsed field line.
+// Only the fields used by the representation are set.
+type FieldLine struct {
+	Type FieldLineType
+
+	// Static is the T bit: the index references the static table.
+	Static bool
+	// Index is the static table index, the relative index or the post-base index.
+	// It is used by all representations except LiteralFieldLineWithLiteralName.
+	Index uint64
+	// NeverIndex is the N bit, which is used by the literal representations.
+	NeverIndex bool
+
+	// Name is only used by LiteralFieldLineWithLiteralName.
+	Name        string
+	NameHuffman bool
+	// Value is used by the literal representations.
+	Value        string
+	ValueHuffman bool
+}
+
+// AppendIndexedFieldLine appends an Indexed Field Line.
+// index is either a static table index or a relative index.
+func AppendIndexedFieldLine(b []byte, static bool, index uint64) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 6, index)
+	// 1Txxxxxx
+	b[offset] |= 0x80
+	if static {
+		b[offset] |= 0x40
+	}
+	return b
+}
+
+// AppendIndexedFieldLinePostBase appends an Indexed Field Line with Post-Base Index.
+func AppendIndexedFieldLinePostBase(b []byte, index uint64) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 4, index)
+	// 0001xxxx
+	b[offset] |= 0x10
+	return b
+}
+
+// AppendLiteralFieldLineWithNameReference appends a Literal Field Line with Name Reference.
+// index is either a static table index or a relative index.
+func AppendLiteralFieldLineWithNameReference(b []byte, neverIndex, static bool, index uint64, value string, huffman bool) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 4, index)
+	// 01NTxxxx
+	b[offset] |= 0x40
+	if neverIndex {
+		b[offset] |= 0x20
+	}
+	if static {
+		b[offset] |= 0x10
+	}
+	return AppendString(b, 7, value, huffman)
+}
+
+// AppendLiteralFieldLineWithPostBaseNameReference appends a Literal Field Line with Post-Base Name Reference.
+func AppendLiteralFieldLineWithPostBaseNameReference(b []byte, neverIndex bool, index uint64, value string, huffman bool) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 3, index)
+	// 0000Nxxx
+	if neverIndex {
+		b[offset] |= 0x08
+	}
+	return AppendString(b, 7, value, huffman)
+}
+
+// AppendLiteralFieldLineWithLiteralName appends a Literal Field Line with Literal Name.
+func AppendLiteralFieldLineWithLiteralName(b []byte, neverIndex bool, name string, nameHuffman bool, value string, valueHuffman bool) []byte {
+	offset := len(b)
+	b = AppendString(b, 3, name, nameHuffman)
+	// 001NHxxx
+	b[offset] |= 0x20
+	if neverIndex {
+		b[offset] |= 0x10
+	}
+	return AppendString(b, 7, value, valueHuffman)
+}
+
+// ParseFieldLine parses a single field line.
+// The error is io.ErrUnexpectedEOF if p doesn't contain the complete field line.
+func ParseFieldLine(p []byte) (_ FieldLine, rest []byte, _ error) {
+	fl, rest, err := parseFieldLine(p)
+	if err != nil {
+		return FieldLine{}, p, err
+	}
+	return fl, rest, nil
+}
+
+// parseFieldLine is like ParseFieldLine, but the returned field line always has its Type set.
+// If a string literal isn't valid Huffman-encoded data, it returns hpack.ErrInvalidHuffman,
+// and rest is positioned after the field line.
+func parseFieldLine(p []byte) (fl FieldLine, rest []byte, _ error) {
+	if len(p) == 0 {
+		return FieldLine{}, p, io.ErrUnexpectedEOF
+	}
+	var err error
+	b := p[0]
+	switch {
+	case b&0x80 > 0: // 1Txxxxxx
+		fl.Type = IndexedFieldLine
+		fl.Static = b&0x40 > 0
+		fl.Index, rest, err = ReadVarInt(6, p)
+		return fl, rest, err
+	case b&0x40 > 0: // 01NTxxxx
+		fl.Type = LiteralFieldLineWithNameReference
+		fl.NeverIndex = b&0x20 > 0
+		fl.Static = b&0x10 > 0
+		fl.Index, rest, err = ReadVarInt(4, p)
+	case b&0x20 > 0: // 001NHxxx
+		fl.Type = LiteralFieldLineWithLiteralName
+		fl.NeverIndex = b&0x10 > 0
+		fl.Name, fl.NameHuffman, rest, err = readString(3, p)
+	case b&0x10 > 0: // 0001xxxx
+		fl.Type = IndexedFieldLinePostBase
+		fl.Index, rest, err = ReadVarInt(4, p)
+		return fl, rest, err
+	default: // 0000Nxxx
+		fl.Type = LiteralFieldLineWithPostBaseNameReference
+		fl.NeverIndex = b&0x08 > 0
+		fl.Index, rest, err = ReadVarInt(3, p)
+	}
+	if err != nil && !errors.Is(err, hpack.ErrInvalidHuffman) {
+		return fl, p, err
+	}
+	var valueErr error
+	fl.Value, fl.ValueHuffman, rest, valueErr = readString(7, rest)
+	if valueErr != nil && !errors.Is(valueErr, hpack.ErrInvalidHuffman) {
+		return fl, p, valueErr
+	}
+	return fl, rest, cmp.Or(err, valueErr)
+}
@@ -0,0 +1,148 @@
+package wire
+
+import (
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestFieldSectionPrefix(t *testing.T) {
+	// Appendix B.2 of RFC 9204: Required Insert Count = 2, Base = 0
+	b := AppendFieldSectionPrefix(nil, FieldSectionPrefix{
+		EncodedInsertCount: EncodeRequiredInsertCount(2, 220),
+		Sign:               true,
+		DeltaBase:          1,
+	})
+	require.Equal(t, unhex(t, "0381"), b)
+	prefix, rest, err := ParseFieldSectionPrefix(append(b, 0x10))
+	require.NoError(t, err)
+	require.Equal(t, FieldSectionPrefix{EncodedInsertCount: 3, Sign: true, DeltaBase: 1}, prefix)
+	require.Equal(t, []byte{0x10}, rest)
+
+	for i := range len(b) {
+		_, _, err := ParseFieldSectionPrefix(b[:i])
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestEncodeRequiredInsertCount(t *testing.T) {
+	require.Zero(t, EncodeRequiredInsertCount(0, 100))
+	require.Zero(t, EncodeRequiredInsertCount(1, 31)) // the dynamic table can't hold any entries
+	// MaxEntries = 3, FullRange = 6
+	require.Equal(t, uint64(2), EncodeRequiredInsertCount(1, 100))
+	require.Equal(t, uint64(6), EncodeRequiredInsertCount(5, 100))
+	require.Equal(t, uint64(1), EncodeRequiredInsertCount(6, 100))
+}
+
+func TestFieldLines(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected FieldLine
+	}{
+		{
+			name:     "indexed, static",
+			data:     AppendIndexedFieldLine(nil, true, 17),
+			expected: FieldLine{Type: IndexedFieldLine, Static: true, Index: 17},
+		},
+		{
+			name:     "indexed, dynamic",
+			data:     AppendIndexedFieldLine(nil, false, 1000),
+			expected: FieldLine{Type: IndexedFieldLine, Index: 1000},
+		},
+		{
+			name:     "indexed, post-base",
+			data:     AppendIndexedFieldLinePostBase(nil, 42),
+			expected: FieldLine{Type: IndexedFieldLinePostBase, Index: 42},
+		},
+		{
+			name: "literal with name reference",
+			data: AppendLiteralFieldLineWithNameReference(nil, true, false, 3, "foobar", true),
+			expected: FieldLine{
+				Type:         LiteralFieldLineWithNameReference,
+				NeverIndex:   true,
+				Index:        3,
+				Value:        "foobar",
+				ValueHuffman: true,
+			},
+		},
+		{
+			name: "literal with static name reference",
+			data: AppendLiteralFieldLineWithNameReference(nil, false, true, 1, "/index.html", false),
+			expected: FieldLine{
+				Type:   LiteralFieldLineWithNameReference,
+				Static: true,
+				Index:  1,
+				Value:  "/index.html",
+			},
+		},
+		{
+			name: "literal with post-base name reference",
+			data: AppendLiteralFieldLineWithPostBaseNameReference(nil, true, 100, "foobar", false),
+			expected: FieldLine{
+				Type:       LiteralFieldLineWithPostBaseNameReference,
+				NeverIndex: true,
+				Index:      100,
+				Value:      "foobar",
+			},
+		},
+		{
+			name: "literal with literal name",
+			data: AppendLiteralFieldLineWithLiteralName(nil, false, "custom-key", false, "custom-value", true),
+			expected: FieldLine{
+				Type:         LiteralFieldLineWithLiteralName,
+				Name:         "custom-key",
+				Value:        "custom-value",
+				ValueHuffman: true,
+			},
+		},
+		{
+			name: "literal with Huffman-encoded literal name",
+			data: AppendLiteralFieldLineWithLiteralName(nil, true, "custom-key", true, "", false),
+			expected: FieldLine{
+				Type:        LiteralFieldLineWithLiteralName,
+				NeverIndex:  true,
+				Name:        "custom-key",
+				NameHuffman: true,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fl, rest, err := ParseFieldLine(append(tc.data, 0x42))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, fl)
+			require.Equal(t, []byte{0x42}, rest)
+
+			for i := range len(tc.data) {
+				_, rest, err := ParseFieldLine(tc.data[:i])
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				require.Equal(t, tc.data[:i], rest)
+			}
+		})
+	}
+}
+
+func TestFieldLinesRFCExamples(t *testing.T) {
+	// Appendix B.1 of RFC 9204
+	require.Equal(t,
+		unhex(t, "510b2f696e6465782e68746d6c"),
+		AppendLiteralFieldLineWithNameReference(nil, false, true, 1, "/index.html", false),
+	)
+	// Appendix B.2 of RFC 9204
+	b := AppendIndexedFieldLinePostBase(nil, 0)
+	b = AppendIndexedFieldLinePostBase(b, 1)
+	require.Equal(t, unhex(t, "1011"), b)
+	// Appendix B.4 of RFC 9204
+	b = AppendIndexedFieldLine(nil, false, 0)
+	b = AppendIndexedFieldLine(b, true, 1)
+	require.Equal(t, unhex(t, "80c1"), b)
+}
@@ -0,0 +1,233 @@
+package wire
+
+import (
+	"cmp"
+	"errors"
+	"io"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// An EncoderInstructionType is the type of an encoder instruction, see Section 4.3 of RFC 9204.
+type EncoderInstructionType uint8
+
+const (
+	// SetDynamicTableCapacity is a Set Dynamic Table Capacity instruction, see Section 4.3.1 of RFC 9204.
+	SetDynamicTableCapacity EncoderInstructionType = iota + 1
+	// InsertWithNameReference is an Insert with Name Reference instruction, see Section 4.3.2 of RFC 9204.
+	InsertWithNameReference
+	// InsertWithLiteralName is an Insert with Literal Name instruction, see Section 4.3.3 of RFC 9204.
+	InsertWithLiteralName
+	// Duplicate is a Duplicate instruction, see Section 4.3.4 of RFC 9204.
+	Duplicate
+)
+
+func (t EncoderInstructionType) String() string {
+	switch t {
+	case SetDynamicTableCapacity:
+		return "Set Dynamic Table Capacity"
+	case InsertWithNameReference:
+		return "Insert with Name Reference"
+	case InsertWithLiteralName:
+		return "Insert with Literal Name"
+	case Duplicate:
+		return "Duplicate"
+	default:
+		return "unknown encoder instruction type"
+	}
+}
+
+// An EncoderInstruction is a parsed encoder instruction.
+// Only the fields used by the instruction are set.
+type EncoderInstruction struct {
+	Type EncoderInstructionType
+
+	// Capacity is only used by SetDynamicTableCapacity.
+	Capacity uint64
+
+	// Static is the T bit of InsertWithNameReference.
+	Static bool
+	// Index is used by InsertWithNameReference and Duplicate.
+	// Relative indices are relative to the Insert Count.
+	Index uint64
+
+	// Name is only used by InsertWithLiteralName.
+	Name        string
+	NameHuffman bool
+	// Value is used by InsertWithNameReference and InsertWithLiteralName.
+	Value        string
+	ValueHuffman bool
+}
+
+// AppendSetDynamicTableCapacity appends a Set Dynamic Table Capacity instruction.
+func AppendSetDynamicTableCapacity(b []byte, capacity uint64) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 5, capacity)
+	// 001xxxxx
+	b[offset] |= 0x20
+	return b
+}
+
+// AppendInsertWithNameReference appends an Insert with Name Reference instruction.
+// index is either a static table index or a relative index.
+func AppendInsertWithNameReference(b []byte, static bool, index uint64, value string, huffman bool) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 6, index)
+	// 1Txxxxxx
+	b[offset] |= 0x80
+	if static {
+		b[offset] |= 0x40
+	}
+	return AppendString(b, 7, value, huffman)
+}
+
+// AppendInsertWithLiteralName appends an Insert with Literal Name instruction.
+func AppendInsertWithLiteralName(b []byte, name string, nameHuffman bool, value string, valueHuffman bool) []byte {
+	offset := len(b)
+	b = AppendString(b, 5, name, nameHuffman)
+	// 01Hxxxxx
+	b[offset] |= 0x40
+	return AppendString(b, 7, value, valueHuffman)
+}
+
+// AppendDuplicate appends a Duplicate instruction.
+func AppendDuplicate(b []byte, index uint64) []byte {
+	// 000xxxxx
+	return AppendVarInt(b, 5, index)
+}
+
+// ParseEncoderInstruction parses a single encoder instruction.
+// The error is io.ErrUnexpectedEOF if p doesn't contain the complete instruction.
+func ParseEncoderInstruction(p []byte) (_ EncoderInstruction, rest []byte, _ error) {
+	in, rest, err := parseEncoderInstruction(p)
+	if err != nil {
+		return EncoderInstruction{}, p, err
+	}
+	return in, rest, nil
+}
+
+// parseEncoderInstruction is like ParseEncoderInstruction, but the returned instruction always has its Type set.
+// If a string literal isn't valid Huffman-encoded data, it returns hpack.ErrInvalidHuffman,
+// and rest is positioned after the instruction.
+func parseEncoderInstruction(p []byte) (in EncoderInstruction, rest []byte, _ error) {
+	if len(p) == 0 {
+		return EncoderInstruction{}, p, io.ErrUnexpectedEOF
+	}
+	var err error
+	b := p[0]
+	switch {
+	case b&0x80 > 0: // 1Txxxxxx
+		in.Type = InsertWithNameReference
+		in.Static = b&0x40 > 0
+		in.Index, rest, err = ReadVarInt(6, p)
+	case b&0x40 > 0: // 01Hxxxxx
+		in.Type = InsertWithLiteralName
+		in.Name, in.NameHuffman, rest, err = readString(5, p)
+	case b&0x20 > 0: // 001xxxxx
+		in.Type = SetDynamicTableCapacity
+		in.Capacity, rest, err = ReadVarInt(5, p)
+		return in, rest, err
+	default: // 000xxxxx
+		in.Type = Duplicate
+		in.Index, rest, err = ReadVarInt(5, p)
+		return in, rest, err
+	}
+	if err != nil && !errors.Is(err, hpack.ErrInvalidHuffman) {
+		return in, p, err
+	}
+	var valueErr error
+	in.Value, in.ValueHuffman, rest, valueErr = readString(7, rest)
+	if valueErr != nil && !errors.Is(valueErr, hpack.ErrInvalidHuffman) {
+		return in, p, valueErr
+	}
+	return in, rest, cmp.Or(err, valueErr)
+}
+
+// A DecoderInstructionType is the type of a decoder instruction, see Section 4.4 of RFC 9204.
+type DecoderInstructionType uint8
+
+const (
+	// SectionAcknowledgment is a Section Acknowledgment instruction, see Section 4.4.1 of RFC 9204.
+	SectionAcknowledgment DecoderInstructionType = iota + 1
+	// StreamCancellation is a Stream Cancellation instruction, see Section 4.4.2 of RFC 9204.
+	StreamCancellation
+	// InsertCountIncrement is an Insert Count Increment instruction, see Section 4.4.3 of RFC 9204.
+	InsertCountIncrement
+)
+
+func (t DecoderInstructionType) String() string {
+	switch t {
+	case SectionAcknowledgment:
+		return "Section Acknowledgment"
+	case StreamCancellation:
+		return "Stream Cancellation"
+	case InsertCountIncrement:
+		return "Insert Count Increment"
+	default:
+		return "unknown decoder instruction type"
+	}
+}
+
+// A DecoderInstruction is a parsed decoder instruction.
+type DecoderInstruction struct {
+	Type DecoderInstructionType
+	// StreamID is used by SectionAcknowledgment and StreamCancellation.
+	StreamID uint64
+	// Increment is only used by InsertCountIncrement.
+	Increment uint64
+}
+
+// AppendSectionAcknowledgment appends a Section Acknowledgment instruction.
+func AppendSectionAcknowledgment(b []byte, streamID uint64) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 7, streamID)
+	// 1xxxxxxx
+	b[offset] |= 0x80
+	return b
+}
+
+// AppendStreamCancellation appends a Stream Cancellation instruction.
+func AppendStreamCancellation(b []byte, streamID uint64) []byte {
+	offset := len(b)
+	b = AppendVarInt(b, 6, streamID)
+	// 01xxxxxx
+	b[offset] |= 0x40
+	return b
+}
+
+// AppendInsertCountIncrement appends an Insert Count Increment instruction.
+func AppendInsertCountIncrement(b []byte, increment uint64) []byte {
+	// 00xxxxxx
+	return AppendVarInt(b, 6, increment)
+}
+
+// ParseDecoderInstruction parses a single decoder instruction.
+// The error is io.ErrUnexpectedEOF if p doesn't contain the complete instruction.
+func ParseDecoderInstruction(p []byte) (_ DecoderInstruction, rest []byte, _ error) {
+	in, rest, err := parseDecoderInstruction(p)
+	if err != nil {
+		return DecoderInstruction{}, p, err
+	}
+	return in, rest, nil
+}
+
+// parseDecoderInstruction is like ParseDecoderInstruction, but the returned instruction always has its Type set.
+func parseDecoderInstruction(p []byte) (in DecoderInstruction, rest []byte, _ error) {
+	if len(p) == 0 {
+		return DecoderInstruction{}, p, io.ErrUnexpectedEOF
+	}
+	var err error
+	b := p[0]
+	switch {
+	case b&0x80 > 0: // 1xxxxxxx
+		in.Type = SectionAcknowledgment
+		in.StreamID, rest, err = ReadVarInt(7, p)
+	case b&0x40 > 0: // 01xxxxxx
+		in.Type = StreamCancellation
+		in.StreamID, rest, err = ReadVarInt(6, p)
+	default: // 00xxxxxx
+		in.Type = InsertCountIncrement
+		in.Increment, rest, err = ReadVarInt(6, p)
+	}
+	return in, rest, err
+}
@@ -0,0 +1,113 @@
+package wire
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoderInstructions(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected EncoderInstruction
+	}{
+		{
+			name:     "Set Dynamic Table Capacity",
+			data:     AppendSetDynamicTableCapacity(nil, 220),
+			expected: EncoderInstruction{Type: SetDynamicTableCapacity, Capacity: 220},
+		},
+		{
+			name:     "Insert with static Name Reference",
+			data:     AppendInsertWithNameReference(nil, true, 0, "www.example.com", true),
+			expected: EncoderInstruction{Type: InsertWithNameReference, Static: true, Index: 0, Value: "www.example.com", ValueHuffman: true},
+		},
+		{
+			name:     "Insert with dynamic Name Reference",
+			data:     AppendInsertWithNameReference(nil, false, 1, "/", false),
+			expected: EncoderInstruction{Type: InsertWithNameReference, Index: 1, Value: "/"},
+		},
+		{
+			name:     "Insert with Literal Name",
+			data:     AppendInsertWithLiteralName(nil, "custom-key", true, "custom-value", false),
+			expected: EncoderInstruction{Type: InsertWithLiteralName, Name: "custom-key", NameHuffman: true, Value: "custom-value"},
+		},
+		{
+			name:     "Duplicate",
+			data:     AppendDuplicate(nil, 1337),
+			expected: EncoderInstruction{Type: Duplicate, Index: 1337},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in, rest, err := ParseEncoderInstruction(append(tc.data, 0x42))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, in)
+			require.Equal(t, []byte{0x42}, rest)
+
+			for i := range len(tc.data) {
+				_, rest, err := ParseEncoderInstruction(tc.data[:i])
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				require.Equal(t, tc.data[:i], rest)
+			}
+		})
+	}
+}
+
+func TestEncoderInstructionsRFCExamples(t *testing.T) {
+	// Appendix B.2 of RFC 9204
+	b := AppendSetDynamicTableCapacity(nil, 220)
+	b = AppendInsertWithNameReference(b, true, 0, "www.example.com", false)
+	b = AppendInsertWithNameReference(b, true, 1, "/sample/path", false)
+	require.Equal(t, unhex(t, "3fbd01c00f7777772e6578616d706c652e636f6dc10c2f73616d706c652f70617468"), b)
+	// Appendix B.3 of RFC 9204
+	b = AppendInsertWithLiteralName(nil, "custom-key", false, "custom-value", false)
+	require.Equal(t, unhex(t, "4a637573746f6d2d6b65790c637573746f6d2d76616c7565"), b)
+	// Appendix B.4 of RFC 9204
+	require.Equal(t, unhex(t, "02"), AppendDuplicate(nil, 2))
+}
+
+func TestDecoderInstructions(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected DecoderInstruction
+	}{
+		{
+			name:     "Section Acknowledgment",
+			data:     AppendSectionAcknowledgment(nil, 4),
+			expected: DecoderInstruction{Type: SectionAcknowledgment, StreamID: 4},
+		},
+		{
+			name:     "Stream Cancellation",
+			data:     AppendStreamCancellation(nil, 1000),
+			expected: DecoderInstruction{Type: StreamCancellation, StreamID: 1000},
+		},
+		{
+			name:     "Insert Count Increment",
+			data:     AppendInsertCountIncrement(nil, 1),
+			expected: DecoderInstruction{Type: InsertCountIncrement, Increment: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in, rest, err := ParseDecoderInstruction(append(tc.data, 0x42))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, in)
+			require.Equal(t, []byte{0x42}, rest)
+
+			for i := range len(tc.data) {
+				_, rest, err := ParseDecoderInstruction(tc.data[:i])
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				require.Equal(t, tc.data[:i], rest)
+			}
+		})
+	}
+}
+
+func TestDecoderInstructionsRFCExamples(t *testing.T) {
+	// Appendix B.2 and B.3 of RFC 9204
+	require.Equal(t, unhex(t, "84"), AppendSectionAcknowledgment(nil, 4))
+	require.Equal(t, unhex(t, "01"), AppendInsertCountIncrement(nil, 1))
+	// Appendix B.5 of RFC 9204
+	require.Equal(t, unhex(t, "48"), AppendStreamCancellation(nil, 8))
+}
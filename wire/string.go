@@ -0,0 +1,58 @@
+package wire
+
+import (
+	"io"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// AppendString appends a string literal with an n bit prefix, see Section 4.1.2 of RFC 9204.
+// The H bit is the bit preceding the prefix. If huffman is set, the string is Huffman-encoded,
+// even if that doesn't save any bytes.
+// The bits preceding the H bit are zero.
+func AppendString(b []byte, n uint8, s string, huffman bool) []byte {
+	if !huffman {
+		b = AppendVarInt(b, n, uint64(len(s)))
+		return append(b, s...)
+	}
+	offset := len(b)
+	b = AppendVarInt(b, n, hpack.HuffmanEncodeLength(s))
+	b[offset] |= 1 << n
+	return hpack.AppendHuffmanString(b, s)
+}
+
+// ReadString reads a string literal with an n bit prefix off the beginning of p,
+// and reports whether it was Huffman-encoded.
+// The bits preceding the H bit are ignored.
+// The error is io.ErrUnexpectedEOF if p doesn't contain the complete string literal.
+func ReadString(n uint8, p []byte) (s string, huffman bool, rest []byte, _ error) {
+	s, huffman, rest, err := readString(n, p)
+	if err != nil {
+		return "", false, p, err
+	}
+	return s, huffman, rest, nil
+}
+
+// readString is like ReadString, but if the string literal isn't valid Huffman-encoded data,
+// it returns hpack.ErrInvalidHuffman, and rest is positioned after the string literal.
+func readString(n uint8, p []byte) (s string, huffman bool, rest []byte, _ error) {
+	if len(p) == 0 {
+		return "", false, p, io.ErrUnexpectedEOF
+	}
+	huffman = p[0]&(1<<n) > 0
+	l, rest, err := ReadVarInt(n, p)
+	if err != nil {
+		return "", false, p, err
+	}
+	if uint64(len(rest)) < l {
+		return "", false, p, io.ErrUnexpectedEOF
+	}
+	if !huffman {
+		return string(rest[:l]), false, rest[l:], nil
+	}
+	s, err = hpack.HuffmanDecodeToString(rest[:l])
+	if err != nil {
+		return "", true, rest[l:], err
+	}
+	return s, true, rest[l:], nil
+}
@@ -1,4 +1,4 @@
-package qpack
+package wire
 
 // copied from the Go standard library HPACK implementation
 
@@ -7,14 +7,16 @@ import (
 	"io"
 )
 
-var errVarintOverflow = errors.New("varint integer overflow")
+// ErrVarintOverflow is returned when a prefix integer doesn't fit into 62 bits.
+// Section 4.1.1 of RFC 9204 only requires decoding integers up to 62 bits.
+var ErrVarintOverflow = errors.New("varint integer overflow")
 
-// appendVarInt appends i, as encoded in variable integer form using n
+// AppendVarInt appends i, as encoded in variable integer form using n
 // bit prefix, to dst and returns the extended buffer.
+// The bits preceding the prefix are zero.
 //
-// See
-// http://http2.github.io/http2-spec/compression.html#integer.representation
-func appendVarInt(dst []byte, n byte, i uint64) []byte {
+// See Section 4.1.1 of RFC 9204.
+func AppendVarInt(dst []byte, n uint8, i uint64) []byte {
 	k := uint64((1 << n) - 1)
 	if i < k {
 		return append(dst, byte(i))
@@ -27,15 +29,14 @@ func appendVarInt(dst []byte, n byte, i uint64) []byte {
 	return append(dst, byte(i))
 }
 
-// readVarInt reads an unsigned variable length integer off the
-// beginning of p. n is the parameter as described in
-// http://http2.github.io/http2-spec/compression.html#rfc.section.5.1.
+// ReadVarInt reads an unsigned variable length integer with an n bit prefix
+// off the beginning of p. The bits preceding the prefix are ignored.
 //
 // n must always be between 1 and 8.
 //
 // The returned remain buffer is either a smaller suffix of p, or err != nil.
 // The error is io.ErrUnexpectedEOF if p doesn't contain a complete integer.
-func readVarInt(n byte, p []byte) (i uint64, remain []byte, err error) {
+func ReadVarInt(n uint8, p []byte) (i uint64, remain []byte, err error) {
 	if n < 1 || n > 8 {
 		panic("bad n")
 	}
@@ -56,13 +57,18 @@ func readVarInt(n byte, p []byte) (i uint64, remain []byte, err error) {
 	for len(p) > 0 {
 		b := p[0]
 		p = p[1:]
+		// i is smaller than 2^62 before adding at most 127 << 56, so this can't overflow a uint64.
 		i += uint64(b&127) << m
+		if i >= 1<<62 {
+			return 0, origP, ErrVarintOverflow
+		}
 		if b&128 == 0 {
 			return i, p, nil
 		}
 		m += 7
-		if m >= 63 { // TODO: proper overflow check. making this up.
-			return 0, origP, errVarintOverflow
+		// Only zero bytes can follow once all 62 bits have been used, so limit their number.
+		if m >= 63 {
+			return 0, origP, ErrVarintOverflow
 		}
 	}
 	return 0, origP, io.ErrUnexpectedEOF
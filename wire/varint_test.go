@@ -0,0 +1,88 @@
+package wire
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVarInt(t *testing.T) {
+	for _, tc := range []struct {
+		n        uint8
+		i        uint64
+		expected []byte
+	}{
+		// examples from Appendix C.1 of RFC 7541
+		{n: 5, i: 10, expected: []byte{0x0a}},
+		{n: 5, i: 1337, expected: []byte{0x1f, 0x9a, 0x0a}},
+		{n: 8, i: 42, expected: []byte{0x2a}},
+		{n: 6, i: 63, expected: []byte{0x3f, 0x00}},
+		{n: 7, i: 1<<62 - 1, expected: AppendVarInt(nil, 7, 1<<62-1)}, // the largest value that fits into 62 bits
+	} {
+		b := AppendVarInt([]byte{0xff}, tc.n, tc.i)
+		require.Equal(t, tc.expected, b[1:])
+		// the bits preceding the prefix are ignored
+		b[1] |= ^byte(0) << tc.n
+		i, rest, err := ReadVarInt(tc.n, append(b[1:], 0x42))
+		require.NoError(t, err)
+		require.Equal(t, tc.i, i)
+		require.Equal(t, []byte{0x42}, rest)
+	}
+}
+
+func TestVarIntErrors(t *testing.T) {
+	t.Run("incomplete", func(t *testing.T) {
+		b := AppendVarInt(nil, 5, 1337)
+		for i := range len(b) {
+			_, rest, err := ReadVarInt(5, b[:i])
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			require.Equal(t, b[:i], rest)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		for _, i := range []uint64{1 << 62, 1<<63 + 1, math.MaxUint64} {
+			b := AppendVarInt(nil, 5, i)
+			_, rest, err := ReadVarInt(5, b)
+			require.ErrorIs(t, err, ErrVarintOverflow)
+			require.Equal(t, b, rest)
+		}
+	})
+}
+
+func TestString(t *testing.T) {
+	for _, huffman := range []bool{false, true} {
+		b := AppendString(nil, 5, "www.example.com", huffman)
+		require.Equal(t, huffman, b[0]&0x20 > 0)
+		require.Zero(t, b[0]&0xc0)
+		s, h, rest, err := ReadString(5, append(b, 0x42))
+		require.NoError(t, err)
+		require.Equal(t, "www.example.com", s)
+		require.Equal(t, huffman, h)
+		require.Equal(t, []byte{0x42}, rest)
+
+		for i := range len(b) {
+			_, _, _, err := ReadString(5, b[:i])
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestStringHuffmanAlways(t *testing.T) {
+	// Huffman encoding makes this string longer
+	b := AppendString(nil, 7, "~|{}^", true)
+	require.Equal(t, byte(0x80), b[0]&0x80)
+	require.Greater(t, len(b), 1+len("~|{}^"))
+	s, huffman, _, err := ReadString(7, b)
+	require.NoError(t, err)
+	require.Equal(t, "~|{}^", s)
+	require.True(t, huffman)
+}
+
+func TestStringInvalidHuffman(t *testing.T) {
+	_, _, _, err := ReadString(7, []byte{0x80 | 1, 0x00})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+}